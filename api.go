@@ -71,7 +71,7 @@ func setupAPI() {
 	homedir := curuser.HomeDir
 
 	luaArgs := rt.NewTable()
-	for i, arg := range os.Args {
+	for i, arg := range args {
 		luaArgs.Set(rt.IntValue(int64(i + 1)), rt.StringValue(arg))
 	}
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"sync"
 
 	rt "github.com/arnodel/golua/runtime"
 	"github.com/arnodel/golua/lib/packagelib"
@@ -11,7 +12,10 @@ import (
 type dirmonitor struct{
 	watcher *fsnotify.Watcher
 	stop chan struct{}
-	callback *rt.Closure
+	// changed paths from the watcher goroutine, until check hands them
+	// to lua on its own thread
+	mu sync.Mutex
+	changes []string
 }
 
 var dirmonitorMetaKey = rt.StringValue("_fezaDirmonitor")
@@ -23,8 +27,8 @@ var dirmonitorLoader = packagelib.Loader{
 func dirmonitorLoad(rtm *rt.Runtime) (rt.Value, func()) {
 	dirmonitorMethods := rt.NewTable()
 	r.SetEnvGoFunc(dirmonitorMethods, "watch", dirmonitorWatch, 2, false)
-	r.SetEnvGoFunc(dirmonitorMethods, "unwatch", dirmonitorWatch, 2, false)
-	r.SetEnvGoFunc(dirmonitorMethods, "check", dirmonitorWatch, 2, false)
+	r.SetEnvGoFunc(dirmonitorMethods, "unwatch", dirmonitorUnwatch, 2, false)
+	r.SetEnvGoFunc(dirmonitorMethods, "check", dirmonitorCheck, 2, false)
 	r.SetEnvGoFunc(dirmonitorMethods, "mode", dirmonitorMode, 1, false)
 
 	dirmonitorMeta := rt.NewTable()
@@ -63,19 +67,22 @@ func dirmonitorNew(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 	stop := make(chan struct{})
-	monitor := &dirmonitor{watcher, stop, nil}
+	monitor := &dirmonitor{watcher: watcher, stop: stop}
 
 	go func(d *dirmonitor, sc chan struct{}) {
-		select {
-			case ev, ok := <-d.watcher.Events:
-				if !ok {
+		for {
+			select {
+				case ev, ok := <-d.watcher.Events:
+					if !ok {
+						return
+					}
+					d.mu.Lock()
+					d.changes = append(d.changes, ev.Name)
+					d.mu.Unlock()
+					postWakeEvent()
+				case <-sc:
 					return
-				}
-				if d.callback != nil {
-					rt.Call1(t.Runtime.MainThread(), rt.FunctionValue(d.callback), rt.StringValue(ev.Name))
-				}
-			case <-sc:
-				return
+			}
 		}
 	}(monitor, stop)
 
@@ -132,9 +139,18 @@ func dirmonitorCheck(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	w.callback = fun
+	w.mu.Lock()
+	changes := w.changes
+	w.changes = nil
+	w.mu.Unlock()
 
-	return c.Next(), nil
+	for _, path := range changes {
+		if _, err := rt.Call1(t, rt.FunctionValue(fun), rt.StringValue(path)); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.PushingNext1(t.Runtime, rt.BoolValue(len(changes) != 0)), nil
 }
 
 func dirmonitorMode(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
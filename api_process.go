@@ -1,19 +1,1105 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
 	rt "github.com/arnodel/golua/runtime"
 	"github.com/arnodel/golua/lib/packagelib"
+	"github.com/creack/pty"
+)
+
+// error codes are negated errnos, like upstream does on posix
+const (
+	PROCESS_ERROR_PIPE = -int64(syscall.EPIPE)
+	PROCESS_ERROR_WOULDBLOCK = -int64(syscall.EWOULDBLOCK)
+	PROCESS_ERROR_TIMEDOUT = -int64(syscall.ETIMEDOUT)
+	PROCESS_ERROR_INVAL = -int64(syscall.EINVAL)
+	PROCESS_ERROR_NOMEM = -int64(syscall.ENOMEM)
+)
+
+const (
+	PROCESS_STREAM_STDIN = iota
+	PROCESS_STREAM_STDOUT
+	PROCESS_STREAM_STDERR
+)
+
+const (
+	PROCESS_WAIT_INFINITE = -1
+	PROCESS_WAIT_DEADLINE = -2
 )
 
+const (
+	PROCESS_REDIRECT_DEFAULT = iota
+	PROCESS_REDIRECT_PIPE
+	PROCESS_REDIRECT_PARENT
+	PROCESS_REDIRECT_DISCARD
+	PROCESS_REDIRECT_STDOUT
+)
+
+// how much output we keep around for lua before we stop reading from the
+// child (and how much input we hold before writes start to would-block)
+const processBufferSize = 1024 * 1024
+
+type process struct{
+	cmd *exec.Cmd
+	stdin *processWriter // nil if not piped
+	stdout *processStream
+	stderr *processStream
+	pty *os.File // master side, if started with a pty
+	pid int // kept since cmd.Process can't be trusted with it after exit
+	timeout time.Duration
+	limits processLimits
+	timedOut int32 // set by the timeout watcher when it kills the process
+	killed int32 // set when the editor signals the process itself
+	limit string // which limit got the process killed, set before done is closed
+	done chan struct{} // closed once the child has been reaped
+}
+
+// processLimits are the resource limits from process.options.
+// Zero values mean no limit.
+type processLimits struct{
+	maxMemory uint64 // bytes
+	maxCPU uint64 // seconds
+	nice int
+	hasNice bool
+}
+
+// processes started through the process module that haven't exited yet,
+// so we don't orphan them on restart or quit
+var processes = map[*process]struct{}{}
+var processesMu sync.Mutex
+
+// how long children get to exit after SIGTERM before they get SIGKILL
+const processKillGrace = time.Second
+
+// how long an exited child's output gets to be read to the end before it
+// counts as exited anyway, for when something it started keeps the pipe open
+const processDrainTimeout = 200 * time.Millisecond
+
+func (p *process) reap() {
+	p.cmd.Wait()
+	p.limit = p.exceededLimit()
+	if p.stdin != nil {
+		p.stdin.exited()
+	}
+	// what the child wrote last is still in the pipes, it has to be
+	// buffered before anyone is told it exited or a read right after
+	// wait would miss it
+	deadline := time.Now().Add(processDrainTimeout)
+	for _, s := range []*processStream{p.stdout, p.stderr} {
+		if s != nil {
+			s.exited()
+			select {
+				case <-s.ended:
+				case <-time.After(time.Until(deadline)):
+			}
+		}
+	}
+	close(p.done)
+
+	processesMu.Lock()
+	delete(processes, p)
+	processesMu.Unlock()
+
+	postProcessExited(p.cmd.Process.Pid, p.returncode(), p.limit)
+}
+
+// watchTimeout kills the process group once the timeout option runs out.
+func (p *process) watchTimeout() {
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+		case <-p.done:
+			return
+		case <-timer.C:
+	}
+
+	atomic.StoreInt32(&p.timedOut, 1)
+	syscall.Kill(-p.cmd.Process.Pid, syscall.SIGTERM)
+
+	select {
+		case <-p.done:
+		case <-time.After(processKillGrace):
+			syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// exceededLimit figures out if the process died because of one of its
+// limits, returning "timeout", "cpu" or "memory" if so. A process the
+// editor killed itself never counts as having hit one.
+func (p *process) exceededLimit() string {
+	if atomic.LoadInt32(&p.timedOut) == 1 {
+		return "timeout"
+	}
+	if atomic.LoadInt32(&p.killed) == 1 {
+		return ""
+	}
+
+	ws, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || (!ws.Signaled() && ws.ExitStatus() == 0) {
+		return ""
+	}
+
+	if p.limits.maxCPU != 0 && ws.Signaled() {
+		// SIGXCPU at the soft limit, SIGKILL at the hard one
+		used := p.cmd.ProcessState.UserTime() + p.cmd.ProcessState.SystemTime()
+		if ws.Signal() == syscall.SIGXCPU || (ws.Signal() == syscall.SIGKILL && used >= time.Duration(p.limits.maxCPU) * time.Second) {
+			return "cpu"
+		}
+	}
+
+	if p.limits.maxMemory != 0 {
+		// the kernel doesn't tell us an allocation failed: the program
+		// sees ENOMEM and then exits with an error, aborts or crashes,
+		// same as it could for any other reason. so it only counts if its
+		// peak memory use was close to the limit too. a single allocation
+		// that's too big on its own doesn't get that far and isn't caught.
+		ru, ok := p.cmd.ProcessState.SysUsage().(*syscall.Rusage)
+		if ok && uint64(ru.Maxrss) * 1024 >= p.limits.maxMemory / 4 * 3 {
+			return "memory"
+		}
+	}
+
+	return ""
+}
+
+// killProcesses terminates every child we started along with anything they
+// spawned in their process groups, and waits for them to be reaped.
+func killProcesses() {
+	processesMu.Lock()
+	procs := make([]*process, 0, len(processes))
+	for p := range processes {
+		procs = append(procs, p)
+	}
+	processesMu.Unlock()
+
+	if len(procs) == 0 {
+		return
+	}
+
+	// every child leads its own group, so a negative pid gets the whole tree
+	for _, p := range procs {
+		atomic.StoreInt32(&p.killed, 1)
+		syscall.Kill(-p.cmd.Process.Pid, syscall.SIGTERM)
+	}
+
+	grace := time.After(processKillGrace)
+	for _, p := range procs {
+		select {
+			case <-p.done:
+			case <-grace:
+		}
+	}
+
+	for _, p := range procs {
+		// also catches leftovers in groups whose leader already exited
+		syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	for _, p := range procs {
+		<-p.done
+	}
+}
+
+func (p *process) running() bool {
+	select {
+		case <-p.done: return false
+		default: return true
+	}
+}
+
+// returncode is the exit code of the child, or 128 + the signal number
+// if a signal killed it, like shells report it.
+func (p *process) returncode() int64 {
+	ws, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if ok && ws.Signaled() {
+		return 128 + int64(ws.Signal())
+	}
+
+	return int64(p.cmd.ProcessState.ExitCode())
+}
+
+// processStream reads a child's output pipe in the background so that
+// lua can take whatever is buffered without ever blocking.
+type processStream struct{
+	mu sync.Mutex
+	cond *sync.Cond
+	buf bytes.Buffer
+	err error // set when the pipe can't be read anymore
+	closed bool
+	// set once the child is gone. what's left in the pipe gets read no
+	// matter how much is buffered, so the pump ends instead of waiting for
+	// lua to make room.
+	done bool
+	f *os.File
+	ended chan struct{} // closed when the pump stops reading
+}
+
+func newProcessStream(f *os.File) *processStream {
+	s := &processStream{f: f, ended: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	go s.pump()
+
+	return s
+}
+
+func (s *processStream) pump() {
+	defer close(s.ended)
+	chunk := make([]byte, 4096)
+	for {
+		s.mu.Lock()
+		for s.buf.Len() >= processBufferSize && !s.closed && !s.done {
+			s.cond.Wait()
+		}
+		s.mu.Unlock()
+
+		n, err := s.f.Read(chunk)
+
+		s.mu.Lock()
+		s.buf.Write(chunk[:n])
+		if n > 0 || err != nil {
+			postWakeEvent()
+		}
+		if err != nil {
+			if s.closed {
+				err = os.ErrClosed
+			}
+			s.err = err
+			s.mu.Unlock()
+			s.f.Close()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+// exited tells the stream its child is gone.
+func (s *processStream) exited() {
+	s.mu.Lock()
+	s.done = true
+	s.cond.Signal()
+	s.mu.Unlock()
+}
+
+// read returns up to n buffered bytes. If nothing is buffered, it returns
+// an empty slice while the pipe is still open, or the error that ended it.
+func (s *processStream) read(n int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buf.Len() == 0 {
+		if s.closed {
+			return nil, os.ErrClosed
+		}
+		return []byte{}, s.err
+	}
+
+	out := make([]byte, n)
+	n, _ = s.buf.Read(out)
+	s.cond.Signal()
+
+	return out[:n], nil
+}
+
+func (s *processStream) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.buf.Reset()
+	s.cond.Signal()
+	s.mu.Unlock()
+
+	s.f.Close()
+}
+
+// processWriter feeds a child's stdin from a background goroutine,
+// so writes from lua only ever copy into a buffer.
+type processWriter struct{
+	mu sync.Mutex
+	cond *sync.Cond
+	buf bytes.Buffer
+	err error
+	closing bool
+	f *os.File
+}
+
+func newProcessWriter(f *os.File) *processWriter {
+	w := &processWriter{f: f}
+	w.cond = sync.NewCond(&w.mu)
+	go w.pump()
+
+	return w
+}
+
+func (w *processWriter) pump() {
+	chunk := make([]byte, 4096)
+	for {
+		w.mu.Lock()
+		for w.buf.Len() == 0 && !w.closing {
+			w.cond.Wait()
+		}
+		if w.buf.Len() == 0 {
+			// closing with everything flushed
+			w.mu.Unlock()
+			w.f.Close()
+			return
+		}
+		n, _ := w.buf.Read(chunk)
+		w.mu.Unlock()
+
+		if _, err := w.f.Write(chunk[:n]); err != nil {
+			w.mu.Lock()
+			w.err = err
+			w.buf.Reset()
+			w.mu.Unlock()
+			w.f.Close()
+			return
+		}
+	}
+}
+
+// exited drops what's still queued once the child is gone and closes
+// stdin, which also ends a write that's stuck on a full pipe.
+func (w *processWriter) exited() {
+	w.mu.Lock()
+	w.closing = true
+	w.buf.Reset()
+	w.cond.Signal()
+	w.mu.Unlock()
+
+	w.f.Close()
+}
+
+// write queues as much of data as fits in the buffer and returns how much
+// that was. A full buffer is reported as EWOULDBLOCK.
+func (w *processWriter) write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closing {
+		return 0, syscall.EPIPE
+	}
+
+	space := processBufferSize - w.buf.Len()
+	if space <= 0 && len(data) != 0 {
+		return 0, syscall.EWOULDBLOCK
+	}
+	if len(data) > space {
+		data = data[:space]
+	}
+	w.buf.Write(data)
+	w.cond.Signal()
+
+	return len(data), nil
+}
+
+// close closes stdin once everything queued so far has been written.
+func (w *processWriter) close() {
+	w.mu.Lock()
+	w.closing = true
+	w.cond.Signal()
+	w.mu.Unlock()
+}
+
+var processMetaKey = rt.StringValue("_fezaProcess")
 var processLoader = packagelib.Loader{
 	Name: "process",
 	Load: processLoad,
 }
 
 func processLoad(rtm *rt.Runtime) (rt.Value, func()) {
-	exports := map[string]luaExport{}
+	processMethods := rt.NewTable()
+	r.SetEnvGoFunc(processMethods, "read", processRead, 3, false)
+	r.SetEnvGoFunc(processMethods, "read_stdout", processReadStdout, 2, false)
+	r.SetEnvGoFunc(processMethods, "read_stderr", processReadStderr, 2, false)
+	r.SetEnvGoFunc(processMethods, "write", processWrite, 2, false)
+	r.SetEnvGoFunc(processMethods, "close_stream", processCloseStream, 2, false)
+	r.SetEnvGoFunc(processMethods, "wait", processWait, 2, false)
+	r.SetEnvGoFunc(processMethods, "terminate", processTerminate, 1, false)
+	r.SetEnvGoFunc(processMethods, "kill", processKill, 1, false)
+	r.SetEnvGoFunc(processMethods, "returncode", processReturncode, 1, false)
+	r.SetEnvGoFunc(processMethods, "running", processRunning, 1, false)
+	r.SetEnvGoFunc(processMethods, "pid", processPid, 1, false)
+	r.SetEnvGoFunc(processMethods, "resize", processResize, 3, false)
+
+	processMeta := rt.NewTable()
+	r.SetEnv(processMeta, "__index", rt.TableValue(processMethods))
+	r.SetRegistry(processMetaKey, rt.TableValue(processMeta))
+
+	exports := map[string]luaExport{
+		"start": {processStart, 2, false},
+		"strerror": {processStrerror, 1, false},
+	}
 	mod := rt.NewTable()
 	setExports(rtm, mod, exports)
 
+	r.SetEnv(mod, "ERROR_PIPE", rt.IntValue(PROCESS_ERROR_PIPE))
+	r.SetEnv(mod, "ERROR_WOULDBLOCK", rt.IntValue(PROCESS_ERROR_WOULDBLOCK))
+	r.SetEnv(mod, "ERROR_TIMEDOUT", rt.IntValue(PROCESS_ERROR_TIMEDOUT))
+	r.SetEnv(mod, "ERROR_INVAL", rt.IntValue(PROCESS_ERROR_INVAL))
+	r.SetEnv(mod, "ERROR_NOMEM", rt.IntValue(PROCESS_ERROR_NOMEM))
+
+	r.SetEnv(mod, "STREAM_STDIN", rt.IntValue(PROCESS_STREAM_STDIN))
+	r.SetEnv(mod, "STREAM_STDOUT", rt.IntValue(PROCESS_STREAM_STDOUT))
+	r.SetEnv(mod, "STREAM_STDERR", rt.IntValue(PROCESS_STREAM_STDERR))
+
+	r.SetEnv(mod, "WAIT_INFINITE", rt.IntValue(PROCESS_WAIT_INFINITE))
+	r.SetEnv(mod, "WAIT_DEADLINE", rt.IntValue(PROCESS_WAIT_DEADLINE))
+
+	r.SetEnv(mod, "REDIRECT_DEFAULT", rt.IntValue(PROCESS_REDIRECT_DEFAULT))
+	r.SetEnv(mod, "REDIRECT_PIPE", rt.IntValue(PROCESS_REDIRECT_PIPE))
+	r.SetEnv(mod, "REDIRECT_PARENT", rt.IntValue(PROCESS_REDIRECT_PARENT))
+	r.SetEnv(mod, "REDIRECT_DISCARD", rt.IntValue(PROCESS_REDIRECT_DISCARD))
+	r.SetEnv(mod, "REDIRECT_STDOUT", rt.IntValue(PROCESS_REDIRECT_STDOUT))
+
 	return rt.TableValue(mod), nil
 }
+
+func processArg(c *rt.GoCont, n int) (*process, error) {
+	p, ok := valueToProcess(c.Arg(n))
+	if ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("#%d must be a process", n+1)
+}
+
+func valueToProcess(v rt.Value) (p *process, ok bool) {
+	var u *rt.UserData
+	u, ok = v.TryUserData()
+	if ok {
+		p, ok = u.Value().(*process)
+	}
+	return
+}
+
+// processErrorCode turns a go error into one of the negated errno codes
+// we give to lua.
+func processErrorCode(err error) int64 {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return -int64(errno)
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return -int64(syscall.ENOENT)
+	}
+
+	return PROCESS_ERROR_INVAL
+}
+
+func processFail(t *rt.Thread, c *rt.GoCont, err error) (rt.Cont, error) {
+	return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue(err.Error()), rt.IntValue(processErrorCode(err))), nil
+}
+
+// mergeEnv returns base with the variables in overrides replaced or added.
+func mergeEnv(base []string, overrides map[string]string) []string {
+	env := make([]string, 0, len(base) + len(overrides))
+	for _, kv := range base {
+		name := kv
+		if idx := strings.IndexByte(kv, '='); idx != -1 {
+			name = kv[:idx]
+		}
+		if _, ok := overrides[name]; ok {
+			continue
+		}
+		env = append(env, kv)
+	}
+	for name, val := range overrides {
+		env = append(env, name + "=" + val)
+	}
+
+	return env
+}
+
+// processBaseEnv is the environment children start from before any env
+// option is applied: the login shell's if feza was asked to use it.
+func processBaseEnv() []string {
+	if useLoginEnv {
+		if env, err := getLoginEnv(); err == nil {
+			return env
+		}
+	}
+
+	return os.Environ()
+}
+
+// lookPathEnv finds file like execvp would with env as the child's
+// environment, which can have a different PATH than ours. names with a
+// slash are left alone: they're relative to the child's cwd, not ours,
+// and exec resolves them after changing to it.
+func lookPathEnv(file string, env []string) (string, error) {
+	if strings.ContainsRune(file, '/') {
+		return file, nil
+	}
+	if env == nil {
+		return exec.LookPath(file)
+	}
+
+	path := ""
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			path = kv[len("PATH="):]
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		full := filepath.Join(dir, file)
+		info, err := os.Stat(full)
+		if err == nil && !info.IsDir() && info.Mode() & 0111 != 0 {
+			return full, nil
+		}
+	}
+
+	return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+}
+
+func processStart(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+
+	cmdTbl, err := c.TableArg(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var argv []string
+	for i := int64(1); i <= cmdTbl.Len(); i++ {
+		arg, ok := cmdTbl.Get(rt.IntValue(i)).TryString()
+		if !ok {
+			return nil, fmt.Errorf("command argument #%d must be a string", i)
+		}
+		argv = append(argv, arg)
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("#1 must not be empty")
+	}
+
+	var opts *rt.Table
+	if !c.Arg(1).IsNil() {
+		opts, err = c.TableArg(1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cmd := &exec.Cmd{Args: argv}
+	p := &process{cmd: cmd, done: make(chan struct{})}
+	stdinMode, stdoutMode, stderrMode := int64(PROCESS_REDIRECT_DEFAULT), int64(PROCESS_REDIRECT_DEFAULT), int64(PROCESS_REDIRECT_DEFAULT)
+	usePty := false
+
+	if opts != nil {
+		if cwd, ok := opts.Get(rt.StringValue("cwd")).TryString(); ok {
+			cmd.Dir = cwd
+		}
+
+		if envTbl, ok := opts.Get(rt.StringValue("env")).TryTable(); ok {
+			overrides := map[string]string{}
+			k, v, _ := envTbl.Next(rt.NilValue)
+			for !k.IsNil() {
+				name, nameOk := k.TryString()
+				val, valOk := v.ToString()
+				if !nameOk || !valOk {
+					return nil, fmt.Errorf("env must be a table of strings")
+				}
+				overrides[name] = val
+				k, v, _ = envTbl.Next(k)
+			}
+			cmd.Env = mergeEnv(processBaseEnv(), overrides)
+		}
+
+		usePty = rt.Truth(opts.Get(rt.StringValue("pty")))
+
+		if timeout, ok := rt.ToFloat(opts.Get(rt.StringValue("timeout"))); ok {
+			p.timeout = time.Duration(timeout * float64(time.Millisecond))
+		}
+		if maxMemory, ok := rt.ToInt(opts.Get(rt.StringValue("max_memory"))); ok && maxMemory > 0 {
+			p.limits.maxMemory = uint64(maxMemory)
+		}
+		if maxCPU, ok := rt.ToInt(opts.Get(rt.StringValue("max_cpu_seconds"))); ok && maxCPU > 0 {
+			p.limits.maxCPU = uint64(maxCPU)
+		}
+		if nice, ok := rt.ToInt(opts.Get(rt.StringValue("nice"))); ok {
+			p.limits.nice = int(nice)
+			p.limits.hasNice = true
+		}
+
+		for name, mode := range map[string]*int64{"stdin": &stdinMode, "stdout": &stdoutMode, "stderr": &stderrMode} {
+			v := opts.Get(rt.StringValue(name))
+			if v.IsNil() {
+				continue
+			}
+			m, ok := rt.ToInt(v)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a redirect mode", name)
+			}
+			*mode = m
+		}
+	}
+
+	if cmd.Env == nil && useLoginEnv {
+		cmd.Env = processBaseEnv()
+	}
+	cmd.Path, err = lookPathEnv(argv[0], cmd.Env)
+	if err != nil {
+		return processFail(t, c, err)
+	}
+
+	if usePty {
+		if err := startPty(p); err != nil {
+			return processFail(t, c, err)
+		}
+	} else {
+		if err := startPiped(p, stdinMode, stdoutMode, stderrMode); err != nil {
+			return processFail(t, c, err)
+		}
+	}
+
+	p.pid = p.cmd.Process.Pid
+	if err := applyProcessLimits(p.cmd.Process.Pid, p.limits); err != nil {
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+		return processFail(t, c, err)
+	}
+
+	processesMu.Lock()
+	processes[p] = struct{}{}
+	processesMu.Unlock()
+	go p.reap()
+	if p.timeout > 0 {
+		go p.watchTimeout()
+	}
+
+	processMeta := t.Registry(processMetaKey)
+	return c.PushingNext1(t.Runtime, t.NewUserDataValue(p, processMeta.AsTable())), nil
+}
+
+// startPiped starts the process with each stdio stream handled as asked
+// by its redirect mode.
+func startPiped(p *process, stdinMode, stdoutMode, stderrMode int64) error {
+	cmd := p.cmd
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// child ends of our pipes, closed once the child has them
+	var childFiles []*os.File
+	var stdinW, stdoutR, stderrR *os.File
+	closeAll := func() {
+		for _, f := range childFiles {
+			f.Close()
+		}
+		for _, f := range []*os.File{stdinW, stdoutR, stderrR} {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}
+
+	switch stdinMode {
+		case PROCESS_REDIRECT_DEFAULT, PROCESS_REDIRECT_PIPE:
+			pr, pw, err := os.Pipe()
+			if err != nil {
+				closeAll()
+				return err
+			}
+			cmd.Stdin = pr
+			stdinW = pw
+			childFiles = append(childFiles, pr)
+		case PROCESS_REDIRECT_PARENT: cmd.Stdin = os.Stdin
+		case PROCESS_REDIRECT_DISCARD:
+		default:
+			return errors.New("invalid redirect mode for stdin")
+	}
+
+	switch stdoutMode {
+		case PROCESS_REDIRECT_DEFAULT, PROCESS_REDIRECT_PIPE:
+			pr, pw, err := os.Pipe()
+			if err != nil {
+				closeAll()
+				return err
+			}
+			cmd.Stdout = pw
+			stdoutR = pr
+			childFiles = append(childFiles, pw)
+		case PROCESS_REDIRECT_PARENT: cmd.Stdout = os.Stdout
+		case PROCESS_REDIRECT_DISCARD:
+		default:
+			closeAll()
+			return errors.New("invalid redirect mode for stdout")
+	}
+
+	switch stderrMode {
+		case PROCESS_REDIRECT_DEFAULT, PROCESS_REDIRECT_PIPE:
+			pr, pw, err := os.Pipe()
+			if err != nil {
+				closeAll()
+				return err
+			}
+			cmd.Stderr = pw
+			stderrR = pr
+			childFiles = append(childFiles, pw)
+		case PROCESS_REDIRECT_PARENT: cmd.Stderr = os.Stderr
+		case PROCESS_REDIRECT_DISCARD:
+		case PROCESS_REDIRECT_STDOUT: cmd.Stderr = cmd.Stdout
+		default:
+			closeAll()
+			return errors.New("invalid redirect mode for stderr")
+	}
+
+	err := cmd.Start()
+	for _, f := range childFiles {
+		f.Close()
+	}
+	childFiles = nil
+	if err != nil {
+		closeAll()
+		return err
+	}
+
+	if stdinW != nil {
+		p.stdin = newProcessWriter(stdinW)
+	}
+	if stdoutR != nil {
+		p.stdout = newProcessStream(stdoutR)
+	}
+	if stderrR != nil {
+		p.stderr = newProcessStream(stderrR)
+	}
+
+	return nil
+}
+
+// startPty starts the process with a pseudo terminal as its stdio.
+// Both stdout and stderr come out of the stdout stream. The child gets
+// its own session, which also makes it a process group leader.
+func startPty(p *process) error {
+	ptmx, err := pty.StartWithSize(p.cmd, &pty.Winsize{Cols: 80, Rows: 24})
+	if err != nil {
+		return err
+	}
+
+	// the writer gets its own descriptor so the reader and writer can
+	// close their side independently
+	var dupfd int
+	var dupErr error
+	if err := withRawFd(ptmx, func(fd uintptr) {
+		dupfd, dupErr = syscall.Dup(int(fd))
+	}); err != nil {
+		dupErr = err
+	}
+	if dupErr != nil {
+		ptmx.Close()
+		p.cmd.Process.Kill()
+		return dupErr
+	}
+	syscall.CloseOnExec(dupfd)
+	syscall.SetNonblock(dupfd, true)
+
+	p.pty = ptmx
+	p.stdin = newProcessWriter(os.NewFile(uintptr(dupfd), ptmx.Name()))
+	p.stdout = newProcessStream(ptmx)
+
+	return nil
+}
+
+// withRawFd runs fn with f's descriptor without switching it to blocking
+// mode like f.Fd() does.
+func withRawFd(f *os.File, fn func(fd uintptr)) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	return conn.Control(fn)
+}
+
+func processStrerror(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	code, err := c.IntArg(0)
+	if err != nil {
+		return nil, err
+	}
+	if code >= 0 {
+		return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+
+	return c.PushingNext1(t.Runtime, rt.StringValue(syscall.Errno(-code).Error())), nil
+}
+
+func processReadStream(t *rt.Thread, c *rt.GoCont, p *process, stream int64, lenArg int) (rt.Cont, error) {
+	size := int64(2048)
+	if !c.Arg(lenArg).IsNil() {
+		var err error
+		size, err = c.IntArg(lenArg)
+		if err != nil {
+			return nil, err
+		}
+		if size <= 0 {
+			return nil, fmt.Errorf("#%d must be a positive integer", lenArg + 1)
+		}
+	}
+
+	var s *processStream
+	switch stream {
+		case PROCESS_STREAM_STDOUT: s = p.stdout
+		case PROCESS_STREAM_STDERR: s = p.stderr
+		default:
+			return nil, fmt.Errorf("invalid stream to read from")
+	}
+	if s == nil {
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue("stream is not redirected to a pipe"), rt.IntValue(PROCESS_ERROR_INVAL)), nil
+	}
+
+	data, err := s.read(int(size))
+	if p.pty != nil && errors.Is(err, syscall.EIO) {
+		// linux reports a pty whose child side is gone as EIO
+		err = io.EOF
+	}
+	if err == io.EOF {
+		// child closed its end; that's the end of the stream, not an error
+		return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+	if err != nil {
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue(err.Error()), rt.IntValue(PROCESS_ERROR_PIPE)), nil
+	}
+
+	return c.PushingNext1(t.Runtime, rt.StringValue(string(data))), nil
+}
+
+func processRead(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.IntArg(1)
+	if err != nil {
+		return nil, err
+	}
+
+	return processReadStream(t, c, p, stream, 2)
+}
+
+func processReadStdout(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return processReadStream(t, c, p, PROCESS_STREAM_STDOUT, 1)
+}
+
+func processReadStderr(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return processReadStream(t, c, p, PROCESS_STREAM_STDERR, 1)
+}
+
+func processWrite(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.stdin == nil {
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue("stream is not redirected to a pipe"), rt.IntValue(PROCESS_ERROR_INVAL)), nil
+	}
+
+	n, err := p.stdin.write([]byte(data))
+	if err != nil {
+		code := PROCESS_ERROR_PIPE
+		if err == syscall.EWOULDBLOCK {
+			code = PROCESS_ERROR_WOULDBLOCK
+		}
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue(err.Error()), rt.IntValue(code)), nil
+	}
+
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(n))), nil
+}
+
+func processCloseStream(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.IntArg(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch stream {
+		case PROCESS_STREAM_STDIN:
+			if p.pty != nil {
+				// a terminal can't be half closed, so send an EOF (^D)
+				p.stdin.write([]byte{4})
+			} else if p.stdin != nil {
+				p.stdin.close()
+			}
+		case PROCESS_STREAM_STDOUT:
+			if p.stdout != nil {
+				p.stdout.close()
+			}
+		case PROCESS_STREAM_STDERR:
+			if p.stderr != nil {
+				p.stderr.close()
+			}
+		default:
+			return nil, fmt.Errorf("invalid stream to close")
+	}
+
+	return c.PushingNext1(t.Runtime, rt.BoolValue(true)), nil
+}
+
+func processWait(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := c.IntArg(1)
+	if err != nil {
+		return nil, err
+	}
+
+	var expire <-chan time.Time
+	switch {
+		case timeout == PROCESS_WAIT_INFINITE:
+		case timeout == PROCESS_WAIT_DEADLINE:
+			// the timeout watcher kills the process once its deadline
+			// is up, so waiting for it to be reaped returns its status
+			// and why it died instead of racing that kill
+		case timeout >= 0:
+			expire = time.After(time.Duration(timeout) * time.Millisecond)
+		default:
+			return nil, fmt.Errorf("#2 must be a timeout in milliseconds or a wait type")
+	}
+
+	// a timer that's already due can still win the select over done, so
+	// a finished process is checked for first
+	if !p.running() {
+		return pushReturncode(t, c, p), nil
+	}
+
+	select {
+		case <-p.done:
+			return pushReturncode(t, c, p), nil
+		case <-expire:
+			return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+}
+
+func processSignal(t *rt.Thread, c *rt.GoCont, sig os.Signal) (rt.Cont, error) {
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.running() {
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue("process is not running"), rt.IntValue(PROCESS_ERROR_INVAL)), nil
+	}
+	atomic.StoreInt32(&p.killed, 1)
+	if err := p.cmd.Process.Signal(sig); err != nil {
+		return processFail(t, c, err)
+	}
+
+	return c.PushingNext1(t.Runtime, rt.BoolValue(true)), nil
+}
+
+func processTerminate(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	return processSignal(t, c, syscall.SIGTERM)
+}
+
+func processKill(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	return processSignal(t, c, syscall.SIGKILL)
+}
+
+func processReturncode(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.running() {
+		return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+
+	return pushReturncode(t, c, p), nil
+}
+
+// pushReturncode pushes the exit status of a finished process, followed by
+// the name of the limit that killed it, if one did.
+func pushReturncode(t *rt.Thread, c *rt.GoCont, p *process) rt.Cont {
+	if p.limit != "" {
+		return c.PushingNext(t.Runtime, rt.IntValue(p.returncode()), rt.StringValue(p.limit))
+	}
+
+	return c.PushingNext1(t.Runtime, rt.IntValue(p.returncode()))
+}
+
+func processRunning(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.PushingNext1(t.Runtime, rt.BoolValue(p.running())), nil
+}
+
+func processPid(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(p.pid))), nil
+}
+
+func processResize(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(3); err != nil {
+		return nil, err
+	}
+	p, err := processArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := c.IntArg(1)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.IntArg(2)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pty == nil {
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue("process was not started with a pty"), rt.IntValue(PROCESS_ERROR_INVAL)), nil
+	}
+	if err := pty.Setsize(p.pty, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)}); err != nil {
+		return processFail(t, c, err)
+	}
+
+	return c.PushingNext1(t.Runtime, rt.BoolValue(true)), nil
+}
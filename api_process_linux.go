@@ -0,0 +1,40 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// applyProcessLimits sets the resource limits of a freshly started process.
+// There's no way to have os/exec do it between fork and exec, so the limits
+// only apply from some point after the child started running: anything it
+// spawned by then keeps our limits and priority, and any memory it had by
+// then counts but can't be refused. The priority is per thread on linux and
+// new threads take it from the one creating them, so threads the child made
+// before this keep ours too.
+func applyProcessLimits(pid int, limits processLimits) error {
+	if limits.maxMemory != 0 {
+		// RLIMIT_AS would also count address space that runtimes like v8
+		// and go reserve up front, RLIMIT_DATA is closer to actual usage
+		lim := &unix.Rlimit{Cur: limits.maxMemory, Max: limits.maxMemory}
+		if err := unix.Prlimit(pid, unix.RLIMIT_DATA, lim, nil); err != nil {
+			return err
+		}
+	}
+
+	if limits.maxCPU != 0 {
+		// SIGXCPU at the soft limit and SIGKILL a second later if the
+		// process ignores it
+		lim := &unix.Rlimit{Cur: limits.maxCPU, Max: limits.maxCPU + 1}
+		if err := unix.Prlimit(pid, unix.RLIMIT_CPU, lim, nil); err != nil {
+			return err
+		}
+	}
+
+	if limits.hasNice {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, pid, limits.nice); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
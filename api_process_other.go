@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// applyProcessLimits is a no-op where we don't have prlimit.
+func applyProcessLimits(pid int, limits processLimits) error {
+	return nil
+}
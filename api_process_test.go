@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// runLua runs code with the api set up and returns what it returns.
+func runLua(t *testing.T, code string) rt.Value {
+	t.Helper()
+	r = rt.New(os.Stdout)
+	lib.LoadAll(r)
+	setupAPI()
+
+	script := filepath.Join(t.TempDir(), "test.lua")
+	if err := os.WriteFile(script, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := doFile(r, script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+// all the output is there to read once wait says the child exited
+func TestProcessReadAfterWait(t *testing.T) {
+	defer killProcesses()
+	v := runLua(t, `
+		for i = 1, 100 do
+			local p = assert(process.start({"echo", "hello"}))
+			p:wait(process.WAIT_INFINITE)
+			local out = p:read_stdout()
+			if out ~= "hello\n" then
+				return string.format("run %d read %q", i, tostring(out))
+			end
+		end
+	`)
+	if !v.IsNil() {
+		t.Error(v.AsString())
+	}
+}
+
+// a grandchild holding the pipe open doesn't hold up wait for long
+func TestProcessWaitWithPipeHeldOpen(t *testing.T) {
+	defer killProcesses()
+	start := time.Now()
+	v := runLua(t, `
+		local p = assert(process.start({"sh", "-c", "sleep 5 & echo hello"}))
+		p:wait(process.WAIT_INFINITE)
+		return p:read_stdout()
+	`)
+	if s, _ := v.TryString(); s != "hello\n" {
+		t.Errorf("read %v", v)
+	}
+	if d := time.Since(start); d > 2 * time.Second {
+		t.Errorf("wait took %v", d)
+	}
+}
+
+// waiting for the deadline returns once the timeout has killed the process
+func TestProcessWaitDeadline(t *testing.T) {
+	defer killProcesses()
+	v := runLua(t, `
+		local p = assert(process.start({"sleep", "5"}, {timeout = 200}))
+		local code, limit = p:wait(process.WAIT_DEADLINE)
+		return string.format("%s %s %s", code, limit, p:running())
+	`)
+	if s, _ := v.TryString(); s != "143 timeout false" {
+		t.Errorf("got %v", v)
+	}
+}
@@ -1,47 +1,110 @@
 package main
 
 import (
+	"container/list"
+	"errors"
 	"fmt"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	rt "github.com/arnodel/golua/runtime"
 	"github.com/arnodel/golua/lib/packagelib"
+	"github.com/dlclark/regexp2"
 )
 
+// match options, same values as pcre2's
 const (
-	REGEX_ANCHORED = iota + 1 << 2
-	REGEX_ENDANCHORED
+	REGEX_NOTBOL = 0x00000001
+	REGEX_NOTEOL = 0x00000002
+	REGEX_NOTEMPTY = 0x00000004
+	REGEX_NOTEMPTY_ATSTART = 0x00000008
+	REGEX_ENDANCHORED = 0x20000000
+	REGEX_ANCHORED = 0x80000000
 )
 
+// error codes, also pcre2's
+const (
+	REGEX_ERROR_INTERNAL = -44
+	REGEX_ERROR_MATCHLIMIT = -47
+)
+
+// a match that runs longer than this is given up on, since it'd be
+// blocking the only lua thread
+const regexMatchTimeout = 500 * time.Millisecond
+const regexCacheSize = 256
+
+var errRegexMatchLimit = errors.New("match limit exceeded, the pattern is taking too long on this subject")
+
+// regexCache holds recently compiled patterns by options and pattern,
+// as syntax highlighting compiles the same ones for each document.
+var regexCache = struct{
+	entries map[string]*list.Element
+	order *list.List // most recently used first
+}{
+	entries: make(map[string]*list.Element),
+	order: list.New(),
+}
+
+type regexCacheEntry struct{
+	key string
+	re *regex
+}
+
 var regexMetaKey = rt.StringValue("_fezaRegexp")
 var regexLoader = packagelib.Loader{
 	Name: "regex",
 	Load: regexLoad,
 }
 
+// regex is a compiled pattern. regexp2 has no match options like pcre
+// does, so those are done by compiling the pattern again with anchors
+// and assertions around it, once per combination that is used.
+type regex struct{
+	*pcrePattern
+	re *regexp2.Regexp
+	pattern string
+	raw bool // match bytes instead of utf-8 characters
+	opts pcreOptions
+	flags regexp2.RegexOptions
+	variants map[int64]*regexp2.Regexp
+}
+
 func regexLoad(rtm *rt.Runtime) (rt.Value, func()) {
 	regexMethods := rt.NewTable()
-	r.SetEnvGoFunc(regexMethods, "cmatch", regexCMatch, 2, false)
-	r.SetEnvGoFunc(regexMethods, "match", regexMatch, 2, false)
+	r.SetEnvGoFunc(regexMethods, "cmatch", regexCMatch, 4, false)
+	r.SetEnvGoFunc(regexMethods, "match", regexMatch, 4, false)
+	r.SetEnvGoFunc(regexMethods, "gmatch", regexGmatch, 3, false)
+	r.SetEnvGoFunc(regexMethods, "gsub", regexGsub, 4, false)
 
 	regexMeta := rt.NewTable()
 	r.SetEnv(regexMeta, "__index", rt.TableValue(regexMethods))
 	r.SetRegistry(regexMetaKey, rt.TableValue(regexMeta))
 
 	exports := map[string]luaExport{
-		"compile": {regexCompile, 1, true},
+		"compile": {regexCompile, 2, false},
+		"cmatch": {regexCMatch, 4, false},
+		"gmatch": {regexGmatch, 3, false},
+		"gsub": {regexGsub, 4, false},
 	}
 	mod := rt.NewTable()
 	setExports(rtm, mod, exports)
 
 	r.SetEnv(mod, "ANCHORED", rt.IntValue(REGEX_ANCHORED))
 	r.SetEnv(mod, "ENDANCHORED", rt.IntValue(REGEX_ENDANCHORED))
+	r.SetEnv(mod, "NOTBOL", rt.IntValue(REGEX_NOTBOL))
+	r.SetEnv(mod, "NOTEOL", rt.IntValue(REGEX_NOTEOL))
+	r.SetEnv(mod, "NOTEMPTY", rt.IntValue(REGEX_NOTEMPTY))
+	r.SetEnv(mod, "NOTEMPTY_ATSTART", rt.IntValue(REGEX_NOTEMPTY_ATSTART))
+	r.SetEnv(mod, "ERROR_INTERNAL", rt.IntValue(REGEX_ERROR_INTERNAL))
+	r.SetEnv(mod, "ERROR_MATCHLIMIT", rt.IntValue(REGEX_ERROR_MATCHLIMIT))
 
 	return rt.TableValue(mod), nil
 }
 
-func regexArg(c *rt.GoCont, n int) (*regexp.Regexp, error) {
+func regexArg(c *rt.GoCont, n int) (*regex, error) {
 	re, ok := valueToRegex(c.Arg(n))
 	if ok {
 		return re, nil
@@ -49,61 +112,494 @@ func regexArg(c *rt.GoCont, n int) (*regexp.Regexp, error) {
 	return nil, fmt.Errorf("#%d must be a regex", n+1)
 }
 
-func valueToRegex(v rt.Value) (re *regexp.Regexp, ok bool) {
+// regexOrPatternArg takes either a compiled regex or a pattern string,
+// like the module functions upstream do.
+func regexOrPatternArg(c *rt.GoCont, n int) (*regex, error) {
+	if re, ok := valueToRegex(c.Arg(n)); ok {
+		return re, nil
+	}
+
+	pat, ok := c.Arg(n).TryString()
+	if !ok {
+		return nil, fmt.Errorf("#%d must be a regex or a string", n+1)
+	}
+
+	return compileRegex(pat, "")
+}
+
+// offsetArg reads an optional 1-based byte offset, where negative ones
+// count from the end. ok is false when it's past the end of the subject.
+func offsetArg(c *rt.GoCont, n int, subject string) (offset int, ok bool, err error) {
+	o := int64(1)
+	if !c.Arg(n).IsNil() {
+		o, err = c.IntArg(n)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	if o < 0 {
+		o += int64(len(subject)) + 1
+	}
+	if o < 1 {
+		o = 1
+	}
+	if o > int64(len(subject)) + 1 {
+		return 0, false, nil
+	}
+
+	return int(o), true, nil
+}
+
+func valueToRegex(v rt.Value) (re *regex, ok bool) {
 	var u *rt.UserData
 	u, ok = v.TryUserData()
 	if ok {
-		re, ok = u.Value().(*regexp.Regexp)
+		re, ok = u.Value().(*regex)
 	}
 	return
 }
 
-func regexCompile(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	if err := c.Check1Arg(); err != nil {
+func regexErrorCode(err error) int64 {
+	if errors.Is(err, errRegexMatchLimit) {
+		return REGEX_ERROR_MATCHLIMIT
+	}
+
+	return REGEX_ERROR_INTERNAL
+}
+
+func regexFail(t *rt.Thread, c *rt.GoCont, err error) (rt.Cont, error) {
+	return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue(err.Error()), rt.IntValue(regexErrorCode(err))), nil
+}
+
+// compileRegex returns the compiled pattern from the cache, or compiles
+// and caches it.
+func compileRegex(pattern, options string) (*regex, error) {
+	var flags strings.Builder
+	for _, f := range "bimsx" {
+		if strings.ContainsRune(options, f) {
+			flags.WriteRune(f)
+		}
+	}
+	key := flags.String() + "/" + pattern
+
+	if e, ok := regexCache.entries[key]; ok {
+		regexCache.order.MoveToFront(e)
+		return e.Value.(*regexCacheEntry).re, nil
+	}
+
+	re, err := newRegex(pattern, options)
+	if err != nil {
 		return nil, err
 	}
 
-	pat, err := c.StringArg(0)
+	regexCache.entries[key] = regexCache.order.PushFront(&regexCacheEntry{key, re})
+	if regexCache.order.Len() > regexCacheSize {
+		oldest := regexCache.order.Back()
+		regexCache.order.Remove(oldest)
+		delete(regexCache.entries, oldest.Value.(*regexCacheEntry).key)
+	}
+
+	return re, nil
+}
+
+func newRegex(pattern, options string) (re *regex, err error) {
+	// like find does for matching, a bug in translating or compiling a
+	// pattern is an error instead of taking the editor down
+	defer func() {
+		if r := recover(); r != nil {
+			re, err = nil, fmt.Errorf("regex compile error: %v", r)
+		}
+	}()
+
+	re = &regex{
+		pattern: pattern,
+		variants: make(map[int64]*regexp2.Regexp),
+	}
+
+	if strings.Contains(options, "i") {
+		re.flags |= regexp2.IgnoreCase
+	}
+	if strings.Contains(options, "s") {
+		re.flags |= regexp2.Singleline
+	}
+	if strings.Contains(options, "m") {
+		re.flags |= regexp2.Multiline
+		re.opts.multiline = true
+	}
+	if strings.Contains(options, "x") {
+		// whitespace and comments are removed by the translation
+		re.opts.extended = true
+	}
+	if strings.Contains(options, "b") {
+		re.raw = true
+	}
+
+	if !re.raw && !utf8.ValidString(pattern) {
+		return nil, fmt.Errorf("pattern is not valid utf-8")
+	}
+	re.pcrePattern, err = pcreToRegexp2(newRegexSubject(pattern, re.raw).runes, re.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	re.re, err = regexp2.Compile(re.expr, re.flags)
+	if err != nil {
+		return nil, err
+	}
+	re.re.MatchTimeout = regexMatchTimeout
+
+	return re, nil
+}
+
+// variant returns the pattern compiled for the given match options.
+func (re *regex) variant(opts int64) (v *regexp2.Regexp, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			v, err = nil, fmt.Errorf("regex compile error: %v", r)
+		}
+	}()
+
+	opts &= REGEX_ANCHORED | REGEX_ENDANCHORED | REGEX_NOTBOL | REGEX_NOTEOL | REGEX_NOTEMPTY | REGEX_NOTEMPTY_ATSTART
+	if opts == 0 {
+		return re.re, nil
+	}
+	if cached, ok := re.variants[opts]; ok {
+		return cached, nil
+	}
+
+	p := re.pcrePattern
+	if opts & (REGEX_NOTBOL | REGEX_NOTEOL) != 0 {
+		popts := re.opts
+		popts.notbol = opts & REGEX_NOTBOL != 0
+		popts.noteol = opts & REGEX_NOTEOL != 0
+		p, err = pcreToRegexp2(newRegexSubject(re.pattern, re.raw).runes, popts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the empty checks capture the rest of the subject where the match
+	// starts, if it's all still there after the match, the match is empty
+	expr := "(?:" + p.expr + ")"
+	switch {
+		case opts & REGEX_NOTEMPTY != 0:
+			expr = `(?=(?<__rest>[\s\S]*))` + expr + `(?!\k<__rest>\z)`
+		case opts & REGEX_NOTEMPTY_ATSTART != 0:
+			expr = `(?:\G(?=(?<__rest>[\s\S]*))|(?!\G))` + expr + `(?(__rest)(?!\k<__rest>\z))`
+	}
+	if opts & REGEX_ANCHORED != 0 {
+		expr = `\G` + expr
+	}
+	if opts & REGEX_ENDANCHORED != 0 {
+		expr += `\z`
+	}
+
+	v, err = regexp2.Compile(expr, re.flags)
 	if err != nil {
 		return nil, err
 	}
+	v.MatchTimeout = regexMatchTimeout
+	re.variants[opts] = v
+
+	return v, nil
+}
 
-	var flags string
-	if len(c.Etc()) != 0 {
-		options, ok := c.Etc()[0].TryString()
-		if !ok {
-			return nil, fmt.Errorf("??")
+// find runs a match, turning a panic in the engine into an error so a bad
+// pattern or subject can't take the editor down with it.
+func (re *regex) find(v *regexp2.Regexp, subj *regexSubject, start int) (m *regexp2.Match, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			m, err = nil, fmt.Errorf("regex matching error: %v", r)
 		}
+	}()
 
-		if strings.Contains(options, "i") {
-			flags += "i"
+	m, err = v.FindRunesMatchStartingAt(subj.runes, start)
+	if err != nil {
+		// the only error regexp2 gives while matching is the timeout
+		return nil, errRegexMatchLimit
+	}
+
+	return m, nil
+}
+
+// lastSubject is the subject made last. the same line tends to be matched
+// over and over (by gmatch, or by a few patterns in turn while
+// highlighting), so it's kept instead of decoded again each time.
+var lastSubject *regexSubject
+
+// subject prepares a string for matching with this regex.
+func (re *regex) subject(s string) *regexSubject {
+	if lastSubject != nil && lastSubject.raw == re.raw && lastSubject.s == s {
+		return lastSubject
+	}
+	lastSubject = newRegexSubject(s, re.raw)
+
+	return lastSubject
+}
+
+// regexSubject is a string prepared for matching. regexp2 works on runes
+// and reports rune indexes, so keep a table to turn those back into
+// byte offsets.
+//
+// Text is decoded as utf-8, with every byte that isn't part of a valid
+// sequence becoming its own U+FFFD, so offsets always land on the bytes
+// they came from and invalid text still matches around the bad bytes.
+// In raw mode every byte is a rune of the same value instead, which makes
+// \xff match the byte 0xff and . match any single byte.
+type regexSubject struct{
+	s string
+	raw bool
+	runes []rune
+	offsets []int // byte offset of each rune, plus one for the end
+}
+
+func newRegexSubject(s string, raw bool) *regexSubject {
+	subj := &regexSubject{
+		s: s,
+		raw: raw,
+		runes: make([]rune, 0, len(s)),
+		offsets: make([]int, 0, len(s) + 1),
+	}
+	if raw {
+		for i := 0; i < len(s); i++ {
+			subj.runes = append(subj.runes, rune(s[i]))
+			subj.offsets = append(subj.offsets, i)
 		}
-		if strings.Contains(options, "s") {
-			flags += "s"
+	} else {
+		// range gives utf8.RuneError for each invalid byte
+		for i, r := range s {
+			subj.runes = append(subj.runes, r)
+			subj.offsets = append(subj.offsets, i)
 		}
-		if strings.Contains(options, "m") {
-			flags += "s"
+	}
+	subj.offsets = append(subj.offsets, len(s))
+
+	return subj
+}
+
+// runeIndex returns the index of the first rune at or after a byte offset,
+// so an offset in the middle of a character starts at the next one.
+func (s *regexSubject) runeIndex(offset int) int {
+	return sort.SearchInts(s.offsets, offset)
+}
+
+// positions returns the 1-based start and end+1 byte offsets of the
+// match and each capture group after it, like pcre's ovector. Trailing
+// groups that didn't participate are left out and ones in the middle
+// are 0, 0.
+func (s *regexSubject) positions(re *regex, m *regexp2.Match) []int64 {
+	last := 0
+	for i := 1; i <= re.groups; i++ {
+		if g := m.GroupByNumber(i); g != nil && len(g.Captures) != 0 {
+			last = i
+		}
+	}
+
+	pos := make([]int64, 0, (last + 1) * 2)
+	for i := 0; i <= last; i++ {
+		g := m.GroupByNumber(i)
+		if g == nil || len(g.Captures) == 0 {
+			pos = append(pos, 0, 0)
+			continue
 		}
+		pos = append(pos, int64(s.offsets[g.Index]) + 1, int64(s.offsets[g.Index + g.Length]) + 1)
+	}
+
+	return pos
+}
+
+// group returns the text of a capture group, and false if it didn't
+// participate in the match.
+func (s *regexSubject) group(m *regexp2.Match, n int) (string, bool) {
+	g := m.GroupByNumber(n)
+	if g == nil || len(g.Captures) == 0 {
+		return "", false
 	}
 
-	if flags != "" {
-		pat = "(?" + flags + ")" + pat
+	return s.s[s.offsets[g.Index]:s.offsets[g.Index + g.Length]], true
+}
+
+// regexMatcher walks through the matches in a subject like pcre2 does when
+// matching globally: after an empty match it tries for a non empty one at
+// the same spot before moving ahead.
+type regexMatcher struct{
+	re *regex
+	subj *regexSubject
+	pos int
+	retry bool
+}
+
+func (rm *regexMatcher) next() (*regexp2.Match, error) {
+	for rm.pos <= len(rm.subj.runes) {
+		v := rm.re.re
+		if rm.retry {
+			var err error
+			v, err = rm.re.variant(REGEX_ANCHORED | REGEX_NOTEMPTY_ATSTART)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		m, err := rm.re.find(v, rm.subj, rm.pos)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			if !rm.retry {
+				rm.pos = len(rm.subj.runes) + 1
+				return nil, nil
+			}
+			rm.retry = false
+			rm.pos++
+			continue
+		}
+
+		rm.pos = m.Index + m.Length
+		rm.retry = m.Length == 0
+		return m, nil
 	}
 
-	re, err := regexp.Compile(pat)
+	return nil, nil
+}
+
+// regexReplacement is a parsed gsub replacement, as literal text and
+// group references.
+type regexReplacement []regexReplacementPart
+
+type regexReplacementPart struct{
+	text string
+	group int // -1 for text
+}
+
+// parseRegexReplacement reads the group references in a replacement:
+// $n, ${n}, ${name}, $name and \n, with $$ and \\ for a literal $ and \.
+func parseRegexReplacement(re *regex, repl string) (regexReplacement, error) {
+	var parts regexReplacement
+	var text strings.Builder
+
+	digits := func(s string) int {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	name := func(s string) int {
+		i := 0
+		for i < len(s) && (s[i] == '_' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z' || s[i] >= '0' && s[i] <= '9') {
+			i++
+		}
+		return i
+	}
+	ref := func(r string, at int) error {
+		n, err := strconv.Atoi(r)
+		if err != nil {
+			var ok bool
+			n, ok = re.names[r]
+			if !ok {
+				return fmt.Errorf("unknown group name %q in replacement at offset %d", r, at)
+			}
+		}
+		if n > re.groups {
+			return fmt.Errorf("reference to non-existent group %d in replacement at offset %d", n, at)
+		}
+
+		parts = append(parts, regexReplacementPart{text: text.String(), group: -1}, regexReplacementPart{group: n})
+		text.Reset()
+		return nil
+	}
+
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		if (c != '$' && c != '\\') || i + 1 == len(repl) {
+			text.WriteByte(c)
+			continue
+		}
+
+		rest := repl[i + 1:]
+		switch {
+			case rest[0] == c:
+				text.WriteByte(c)
+				i++
+			case c == '$' && rest[0] == '{':
+				end := strings.IndexByte(rest, '}')
+				if end == -1 {
+					return nil, fmt.Errorf("missing } in replacement at offset %d", i)
+				}
+				if err := ref(rest[1:end], i); err != nil {
+					return nil, err
+				}
+				i += end + 1
+			case digits(rest) > 0:
+				n := digits(rest)
+				if err := ref(rest[:n], i); err != nil {
+					return nil, err
+				}
+				i += n
+			case c == '$' && name(rest) > 0:
+				n := name(rest)
+				if err := ref(rest[:n], i); err != nil {
+					return nil, err
+				}
+				i += n
+			default:
+				text.WriteByte(c)
+		}
+	}
+	parts = append(parts, regexReplacementPart{text: text.String(), group: -1})
+
+	return parts, nil
+}
+
+// expand writes the replacement for a match, groups that didn't
+// participate are empty.
+func (rp regexReplacement) expand(b *strings.Builder, subj *regexSubject, m *regexp2.Match) {
+	for _, part := range rp {
+		if part.group == -1 {
+			b.WriteString(part.text)
+			continue
+		}
+		g, _ := subj.group(m, part.group)
+		b.WriteString(g)
+	}
+}
+
+func regexCompile(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+
+	pat, err := c.StringArg(0)
 	if err != nil {
 		return nil, err
 	}
 
+	var options string
+	if !c.Arg(1).IsNil() {
+		options, err = c.StringArg(1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	re, err := compileRegex(pat, options)
+	if err != nil {
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue(err.Error())), nil
+	}
+
 	regexMeta := t.Registry(regexMetaKey)
 	return c.PushingNext1(t.Runtime, t.NewUserDataValue(re, regexMeta.AsTable())), nil
 }
 
+// match works like string.match: it returns the captures of the first
+// match, or the whole match if there are none. Empty captures give their
+// offset instead, same as regex.match in core.
 func regexMatch(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	return c.Next(), nil
-}
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
 
-func regexCMatch(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	re, err := regexArg(c, 0)
 	if err != nil {
 		return nil, err
@@ -114,58 +610,206 @@ func regexCMatch(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	var indexes [][]int
-	if len(c.Etc()) != 0 {
-		offset, ok := c.Etc()[0].TryInt()
-		if !ok {
-			return nil, fmt.Errorf("??")
+	offset, ok, err := offsetArg(c, 2, subject)
+	if err != nil || !ok {
+		return c.Next(), err
+	}
+
+	var opts int64
+	if !c.Arg(3).IsNil() {
+		opts, err = c.IntArg(3)
+		if err != nil {
+			return nil, err
 		}
-		var opts int64
-		if len(c.Etc()) > 1 {
-			var ok bool
-			opts, ok = c.Etc()[0].TryInt()
-			if !ok {
-				return nil, fmt.Errorf("??")
-			}
+	}
+
+	v, err := re.variant(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	subj := re.subject(subject)
+	m, err := re.find(v, subj, subj.runeIndex(offset - 1))
+	if err != nil {
+		return regexFail(t, c, err)
+	}
+	if m == nil {
+		return c.Next(), nil
+	}
+
+	pos := subj.positions(re, m)
+	if len(pos) == 2 {
+		return c.PushingNext1(t.Runtime, rt.StringValue(subject[pos[0] - 1:pos[1] - 1])), nil
+	}
+
+	next := c.Next()
+	for i := 2; i < len(pos); i += 2 {
+		if pos[i] >= pos[i + 1] {
+			t.Push1(next, rt.IntValue(pos[i]))
+		} else {
+			t.Push1(next, rt.StringValue(subject[pos[i] - 1:pos[i + 1] - 1]))
 		}
+	}
 
-		match := true
-		anchored := opts & REGEX_ANCHORED == REGEX_ANCHORED
-		endAnchored := opts & REGEX_ENDANCHORED == REGEX_ENDANCHORED;
+	return next, nil
+}
 
-		ln := re.FindStringIndex(subject)
-		if anchored {
-			start := ln[0]
-			if int64(start) + offset != offset {
-				match = false
-			}
+func regexGmatch(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+
+	re, err := regexOrPatternArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, ok, err := offsetArg(c, 2, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	subj := re.subject(subject)
+	rm := &regexMatcher{re: re, subj: subj, pos: len(subj.runes) + 1}
+	if ok {
+		rm.pos = subj.runeIndex(offset - 1)
+	}
+
+	iter := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+		m, err := rm.next()
+		if err != nil || m == nil {
+			return c.Next(), err
+		}
+
+		if re.groups == 0 {
+			whole, _ := subj.group(m, 0)
+			return c.PushingNext1(t.Runtime, rt.StringValue(whole)), nil
 		}
 
-		if endAnchored {
-			end := ln[1]
-			if end != len(subject) {
-				match = false
+		next := c.Next()
+		for i := 1; i <= re.groups; i++ {
+			if g, ok := subj.group(m, i); ok {
+				t.Push1(next, rt.StringValue(g))
+			} else {
+				t.Push1(next, rt.NilValue)
 			}
 		}
 
-		if match {
-			indexes = append(indexes, ln)
+		return next, nil
+	}
+
+	return c.PushingNext1(t.Runtime, rt.FunctionValue(rt.NewGoFunction(iter, "gmatchiterator", 0, false))), nil
+}
+
+func regexGsub(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(3); err != nil {
+		return nil, err
+	}
+
+	re, err := regexOrPatternArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
+	}
+
+	replacement, err := c.StringArg(2)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := int64(-1)
+	if !c.Arg(3).IsNil() {
+		limit, err = c.IntArg(3)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	repl, err := parseRegexReplacement(re, replacement)
+	if err != nil {
+		return nil, err
+	}
+
+	subj := re.subject(subject)
+	rm := &regexMatcher{re: re, subj: subj}
+
+	var b strings.Builder
+	var count int64
+	last := 0
+	for limit < 0 || count < limit {
+		m, err := rm.next()
+		if err != nil {
+			return regexFail(t, c, err)
+		}
+		if m == nil {
+			break
 		}
+
+		b.WriteString(subject[last:subj.offsets[m.Index]])
+		repl.expand(&b, subj, m)
+		last = subj.offsets[m.Index + m.Length]
+		count++
+	}
+	b.WriteString(subject[last:])
+
+	return c.PushingNext(t.Runtime, rt.StringValue(b.String()), rt.IntValue(count)), nil
+}
+
+func regexCMatch(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+
+	re, err := regexArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := c.StringArg(1)
+	if err != nil {
+		return nil, err
 	}
 
-	luaIndexes := rt.NewTable()
-	if len(indexes) == 0 && len(c.Etc()) == 0 {
-		indexes = re.FindAllStringIndex(subject, -1)
+	offset, ok, err := offsetArg(c, 2, subject)
+	if err != nil || !ok {
+		return c.Next(), err
 	}
 
-	for v, idxSlice := range indexes {
-		luaIdxSlice := rt.NewTable()
-		for vv, i := range idxSlice {
-			luaIdxSlice.Set(rt.IntValue(int64(vv)), rt.IntValue(int64(i)))
+	var opts int64
+	if !c.Arg(3).IsNil() {
+		opts, err = c.IntArg(3)
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	v, err := re.variant(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	subj := re.subject(subject)
+	m, err := re.find(v, subj, subj.runeIndex(offset - 1))
+	if err != nil {
+		return regexFail(t, c, err)
+	}
+	if m == nil {
+		return c.Next(), nil
+	}
 
-		luaIndexes.Set(rt.IntValue(int64(v)), rt.TableValue(luaIdxSlice))
+	next := c.Next()
+	for _, pos := range subj.positions(re, m) {
+		t.Push1(next, rt.IntValue(pos))
 	}
 
-	return c.PushingNext1(t.Runtime, rt.TableValue(luaIndexes)), nil
+	return next, nil
 }
@@ -2,10 +2,10 @@ package main
 
 import (
 	"fmt"
+	imgcolor "image/color"
 
 	rt "github.com/arnodel/golua/runtime"
 	"github.com/arnodel/golua/lib/packagelib"
-	"github.com/tfriedel6/canvas"
 )
 
 var rendererLoader = packagelib.Loader{
@@ -21,6 +21,8 @@ func rendererLoad(rtm *rt.Runtime) (rt.Value, func()) {
 		"draw_text": {rendererDrawText, 5, false},
 		"set_clip_rect": {rendererClipRect, 4, false},
 		"get_size": {rendererGetSize, 0, false},
+		"bidi": {rendererBidi, 1, false},
+		"show_debug": {rendererShowDebug, 1, false},
 	}
 	mod := rt.NewTable()
 	setExports(rtm, mod, exports)
@@ -31,12 +33,14 @@ func rendererLoad(rtm *rt.Runtime) (rt.Value, func()) {
 }
 
 func rendererBeginFrame(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	wnd.StartFrame()
+	rend.beginFrame()
+
 	return c.Next(), nil
 }
 
 func rendererEndFrame(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	wnd.FinishFrame()
+	rend.endFrame()
+
 	return c.Next(), nil
 }
 
@@ -66,13 +70,12 @@ func rendererDrawRect(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	r := int(color.Get(rt.IntValue(1)).AsInt())
-	g := int(color.Get(rt.IntValue(2)).AsInt())
-	b := int(color.Get(rt.IntValue(3)).AsInt())
-	a := int(color.Get(rt.IntValue(4)).AsInt())
+	r := uint8(color.Get(rt.IntValue(1)).AsInt())
+	g := uint8(color.Get(rt.IntValue(2)).AsInt())
+	b := uint8(color.Get(rt.IntValue(3)).AsInt())
+	a := uint8(color.Get(rt.IntValue(4)).AsInt())
 
-	cv.SetFillStyle(r, g, b, a)
-	cv.FillRect(x, y, w, h)
+	rend.drawRect(x, y, w, h, imgcolor.RGBA{r, g, b, a})
 
 	return c.Next(), nil
 }
@@ -99,14 +102,13 @@ func rendererClipRect(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	cv.Rect(x, y, w, h)
-	//cv.Clip()
+	rend.setClip(&[4]float64{x, y, w, h})
 
 	return c.Next(), nil
 }
 
 func rendererGetSize(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	w, h := wnd.Window.GetSize()
+	w, h := rend.size()
 
 	return c.PushingNext(t.Runtime, rt.IntValue(int64(w)), rt.IntValue(int64(h))), nil
 }
@@ -138,33 +140,78 @@ func rendererDrawText(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	r := int(color.Get(rt.IntValue(1)).AsInt())
-	g := int(color.Get(rt.IntValue(2)).AsInt())
-	b := int(color.Get(rt.IntValue(3)).AsInt())
-	a := int(color.Get(rt.IntValue(4)).AsInt())
+	r := uint8(color.Get(rt.IntValue(1)).AsInt())
+	g := uint8(color.Get(rt.IntValue(2)).AsInt())
+	b := uint8(color.Get(rt.IntValue(3)).AsInt())
+	a := uint8(color.Get(rt.IntValue(4)).AsInt())
+
+	tx := rend.drawText(fnt, []textSpan{{text, imgcolor.RGBA{r, g, b, a}}}, x, y)
+
+	return c.PushingNext1(t.Runtime, rt.FloatValue(tx)), nil
+}
 
-	cv.SetFont(fnt.f, float64(fnt.size))
-	cv.SetFillStyle(r, g, b, a)
-	cv.FillText(text, x, y)
-	metrics := cv.MeasureText(text)
+func rendererShowDebug(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
 
-	tx := x + metrics.Width // not sure how this will work
+	if cache, ok := rend.(*renCache); ok {
+		cache.debug = rt.Truth(c.Arg(0))
+	}
 
-	return c.PushingNext1(t.Runtime, rt.IntValue(int64(tx))), nil
+	return c.Next(), nil
 }
 
-var fontMetaKey = rt.StringValue("_fezaFont")
-type font struct{
-	f *canvas.Font
-	size int
+func rendererBidi(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+
+	text, err := c.StringArg(0)
+	if err != nil {
+		return nil, err
+	}
+
+	runs, rtl := bidiRuns(text)
+	visual := bidiOrder(text, runs)
+
+	// offsets are 1-based and inclusive, like string.sub
+	runsTbl := rt.NewTable()
+	for i, run := range runs {
+		runTbl := rt.NewTable()
+		runTbl.Set(rt.IntValue(1), rt.IntValue(int64(run.start + 1)))
+		runTbl.Set(rt.IntValue(2), rt.IntValue(int64(run.end)))
+		runTbl.Set(rt.StringValue("rtl"), rt.BoolValue(run.rtl))
+		runsTbl.Set(rt.IntValue(int64(i + 1)), rt.TableValue(runTbl))
+	}
+
+	toLogical := rt.NewTable()
+	toVisual := rt.NewTable()
+	for i, off := range visual {
+		toLogical.Set(rt.IntValue(int64(i + 1)), rt.IntValue(int64(off + 1)))
+		toVisual.Set(rt.IntValue(int64(off + 1)), rt.IntValue(int64(i + 1)))
+	}
+
+	tbl := rt.NewTable()
+	tbl.Set(rt.StringValue("rtl"), rt.BoolValue(rtl))
+	tbl.Set(rt.StringValue("runs"), rt.TableValue(runsTbl))
+	tbl.Set(rt.StringValue("visual_to_logical"), rt.TableValue(toLogical))
+	tbl.Set(rt.StringValue("logical_to_visual"), rt.TableValue(toVisual))
+
+	return c.PushingNext1(t.Runtime, rt.TableValue(tbl)), nil
 }
 
+var fontMetaKey = rt.StringValue("_fezaFont")
+
 func rFontLoader(rtm *rt.Runtime) rt.Value {
 	fontMethods := rt.NewTable()
 	r.SetEnvGoFunc(fontMethods, "copy", rFontCopy, 1, true)
 	r.SetEnvGoFunc(fontMethods, "get_height", rFontHeight, 1, true)
-	r.SetEnvGoFunc(fontMethods, "get_width", rFontWidth, 1, true)
-	r.SetEnvGoFunc(fontMethods, "set_tab_size", luaStub, 1, true)
+	r.SetEnvGoFunc(fontMethods, "get_width", rFontWidth, 2, false)
+	r.SetEnvGoFunc(fontMethods, "set_tab_size", rFontSetTabSize, 2, false)
+	r.SetEnvGoFunc(fontMethods, "get_size", rFontGetSize, 1, false)
+	r.SetEnvGoFunc(fontMethods, "set_size", rFontSetSize, 2, false)
+	r.SetEnvGoFunc(fontMethods, "get_path", rFontGetPath, 1, false)
 
 	fontMeta := rt.NewTable()
 	r.SetEnv(fontMeta, "__index", rt.TableValue(fontMethods))
@@ -172,6 +219,7 @@ func rFontLoader(rtm *rt.Runtime) rt.Value {
 
 	exports := map[string]luaExport{
 		"load": {rFontLoad, 2, true},
+		"group": {rFontGroup, 1, false},
 	}
 	mod := rt.NewTable()
 	setExports(rtm, mod, exports)
@@ -196,6 +244,51 @@ func valueToFont(v rt.Value) (f *font, ok bool) {
 	return
 }
 
+// fontOptionsArg reads a renderer.fontoptions table over opts.
+func fontOptionsArg(v rt.Value, n int, opts fontOptions) (fontOptions, error) {
+	if v.IsNil() {
+		return opts, nil
+	}
+	tbl, ok := v.TryTable()
+	if !ok {
+		return opts, fmt.Errorf("#%d must be a table", n)
+	}
+
+	if aa := tbl.Get(rt.StringValue("antialiasing")); !aa.IsNil() {
+		s, _ := aa.TryString()
+		switch s {
+			case "none": opts.antialiasing = fontAntialiasingNone
+			// there's no lcd rendering, subpixel is taken as grayscale
+			case "grayscale", "subpixel": opts.antialiasing = fontAntialiasingGrayscale
+			default: return opts, fmt.Errorf("invalid antialiasing mode '%s'", s)
+		}
+	}
+	if hinting := tbl.Get(rt.StringValue("hinting")); !hinting.IsNil() {
+		s, _ := hinting.TryString()
+		switch s {
+			case "none": opts.hinting = fontHintingNone
+			case "slight": opts.hinting = fontHintingSlight
+			case "full": opts.hinting = fontHintingFull
+			default: return opts, fmt.Errorf("invalid hinting mode '%s'", s)
+		}
+	}
+
+	styles := map[string]*bool{
+		"bold": &opts.bold,
+		"italic": &opts.italic,
+		"underline": &opts.underline,
+		"strikethrough": &opts.strikethrough,
+		"smoothing": &opts.smoothing,
+	}
+	for name, field := range styles {
+		if style := tbl.Get(rt.StringValue(name)); !style.IsNil() {
+			*field = rt.Truth(style)
+		}
+	}
+
+	return opts, nil
+}
+
 func rFontLoad(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err := c.CheckNArgs(2); err != nil {
 		return nil, err
@@ -210,44 +303,98 @@ func rFontLoad(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	fnt, err := cv.LoadFont(path)
+	opts := defaultFontOptions
+	if len(c.Etc()) != 0 {
+		opts, err = fontOptionsArg(c.Etc()[0], 3, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := loadFontFile(path)
 	if err != nil {
 		return nil, err
 	}
-	f := &font{fnt, int(size)}
+	f := newFont(newFontFace(file, size, opts))
 
 	fontMeta := t.Registry(fontMetaKey)
 	return c.PushingNext1(t.Runtime, t.NewUserDataValue(f, fontMeta.AsTable())), nil
 }
 
+func rFontGroup(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+
+	fonts, err := c.TableArg(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var faces []*fontFace
+	var tabSize int
+	for i := int64(1); ; i++ {
+		v := fonts.Get(rt.IntValue(i))
+		if v.IsNil() {
+			break
+		}
+		fnt, ok := valueToFont(v)
+		if !ok {
+			return nil, fmt.Errorf("font %d in group is not a font", i)
+		}
+		if i == 1 {
+			tabSize = fnt.tabSize
+		}
+		// groups of groups flatten into one
+		faces = append(faces, fnt.faces...)
+	}
+	if len(faces) == 0 {
+		return nil, fmt.Errorf("font group must have at least one font")
+	}
+
+	f := newFont(faces...)
+	f.tabSize = tabSize
+	fontMeta := t.Registry(fontMetaKey)
+	return c.PushingNext1(t.Runtime, t.NewUserDataValue(f, fontMeta.AsTable())), nil
+}
+
 func rFontCopy(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	fnt, err := fontArg(c, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	size := fnt.size
-	if len(c.Etc()) != 0 {
-		sz, ok := c.Etc()[0].TryFloat()
+	size := fnt.size()
+	if len(c.Etc()) != 0 && !c.Etc()[0].IsNil() {
+		sz, ok := rt.ToFloat(c.Etc()[0])
 		if !ok {
-			return nil, fmt.Errorf("???")
+			return nil, fmt.Errorf("#2 must be a number")
+		}
+		size = float64(sz)
+	}
+
+	var opts *fontOptions
+	if len(c.Etc()) > 1 && !c.Etc()[1].IsNil() {
+		// options not given keep what the font had
+		o, err := fontOptionsArg(c.Etc()[1], 3, fnt.faces[0].opts)
+		if err != nil {
+			return nil, err
 		}
-		size = int(sz)
-		fmt.Println(size)
+		opts = &o
 	}
 
-	f := &font{fnt.f, size}
+	f := fnt.copy(size, opts)
 	fontMeta := t.Registry(fontMetaKey)
 	return c.PushingNext1(t.Runtime, t.NewUserDataValue(f, fontMeta.AsTable())), nil
 }
 
 func rFontHeight(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	_, err := fontArg(c, 0)
+	fnt, err := fontArg(c, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.PushingNext1(t.Runtime, rt.IntValue(20)), nil
+	return c.PushingNext1(t.Runtime, rt.FloatValue(fnt.height())), nil
 }
 
 func rFontWidth(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
@@ -256,17 +403,76 @@ func rFontWidth(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-/*
 	text, err := c.StringArg(1)
 	if err != nil {
 		return nil, err
 	}
-*/
-	text := "lol"
-	cv.Save()
-	cv.SetFont(fnt.f, float64(fnt.size))
-	metrics := cv.MeasureText(text)
-	cv.Restore()
-	
-	return c.PushingNext1(t.Runtime, rt.FloatValue(metrics.Width)), nil
+
+	return c.PushingNext1(t.Runtime, rt.FloatValue(fnt.width(text))), nil
+}
+
+func rFontSetTabSize(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+
+	fnt, err := fontArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := c.IntArg(1)
+	if err != nil {
+		return nil, err
+	}
+	fnt.tabSize = int(size)
+
+	return c.Next(), nil
+}
+
+func rFontGetSize(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	fnt, err := fontArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.PushingNext1(t.Runtime, rt.FloatValue(fnt.size())), nil
+}
+
+func rFontSetSize(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+
+	fnt, err := fontArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := c.FloatArg(1)
+	if err != nil {
+		return nil, err
+	}
+	fnt.setSize(size)
+
+	return c.Next(), nil
+}
+
+func rFontGetPath(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	fnt, err := fontArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := fnt.paths()
+	if len(paths) == 1 {
+		return c.PushingNext1(t.Runtime, rt.StringValue(paths[0])), nil
+	}
+
+	tbl := rt.NewTable()
+	for i, path := range paths {
+		tbl.Set(rt.IntValue(int64(i + 1)), rt.StringValue(path))
+	}
+
+	return c.PushingNext1(t.Runtime, rt.TableValue(tbl)), nil
 }
@@ -1,15 +1,20 @@
 package main
 
 import (
-		"fmt"
+	"bytes"
+	"context"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	rt "github.com/arnodel/golua/runtime"
 	"github.com/arnodel/golua/lib/packagelib"
-	"github.com/veandco/go-sdl2/sdl"
 )
 
 var systemLoader = packagelib.Loader{
@@ -41,7 +46,8 @@ func systemLoad(rtm *rt.Runtime) (rt.Value, func()) {
 		// get_process_id
 		"get_time": {systemGetTime, 0, false},
 		"sleep": {systemSleep, 1, false},
-		// exec (don't return, non blocking)
+		"exec": {systemExec, 1, false},
+		"get_login_env": {systemGetLoginEnv, 0, false},
 		// fuzzy_match
 		"set_window_opacity": {systemSetWindowOpacity, 1, false},
 		// these below aren't documented in docs/system.lua of lite xl upstream
@@ -78,103 +84,67 @@ func stv(str string) rt.Value {
 	return rt.StringValue(str)
 }
 
-var i = 0
-func systemPollEvent(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	n := c.Next()
-	//fmt.Println("poll called. waiting")
-poll:
-	event := sdl.PollEvent()
-	fmt.Println(event)
-	if event == nil {
-		goto poll
-	}
-
-	//fmt.Println("past poll call", i)
-	i++
-
-	switch e := event.(type) {
-		case *sdl.QuitEvent:
-			n.Push(t.Runtime, stv("quit"))
-		// Window Events
-		case *sdl.WindowEvent:
-			switch e.Event {
-				case sdl.WINDOWEVENT_EXPOSED: n.Push(t.Runtime, stv("exposed"))
-				case sdl.WINDOWEVENT_RESTORED: n.Push(t.Runtime, stv("restored"))
-				case sdl.WINDOWEVENT_RESIZED:
-					w := e.Data1
-					h := e.Data2
-					n.Push(t.Runtime, stv("resized"))
-					n.Push(t.Runtime, itv(int64(w)))
-					n.Push(t.Runtime, itv(int64(h)))
-				default:
-					goto poll
-			}
-		// Mouse Events
-		case *sdl.MouseButtonEvent:
-			typ := "mousereleased"
-			if e.Type == sdl.MOUSEBUTTONDOWN {
-				typ = "mousepressed"
-			}
-			n.Push(t.Runtime, stv(typ))
-	
-			var buttonName string
-			switch e.Button {
-				case sdl.BUTTON_LEFT: buttonName = "left"
-				case sdl.BUTTON_MIDDLE: buttonName = "middle"
-				case sdl.BUTTON_RIGHT: buttonName = "right"
-				case sdl.BUTTON_X1: buttonName = "x"
-				case sdl.BUTTON_X2: buttonName = "y"
-			}
-			n.Push(t.Runtime, stv(buttonName))
-			n.Push(t.Runtime, itv(int64(e.X)))
-			n.Push(t.Runtime, itv(int64(e.Y)))
-			n.Push(t.Runtime, itv(int64(e.Clicks)))
-		case *sdl.MouseMotionEvent:
-			n.Push(t.Runtime, stv("mousemoved"))
-			n.Push(t.Runtime, itv(int64(e.X)))
-			n.Push(t.Runtime, itv(int64(e.Y)))
-			n.Push(t.Runtime, itv(int64(e.XRel)))
-			n.Push(t.Runtime, itv(int64(e.YRel)))
-		case *sdl.MouseWheelEvent:
-			n.Push(t.Runtime, stv("mousewheel"))
-			n.Push(t.Runtime, itv(int64(e.Y)))
-		case *sdl.KeyboardEvent:
-			if e.State == sdl.PRESSED {
-				n.Push(t.Runtime, stv("keypressed"))
-			} else {
-				n.Push(t.Runtime, stv("keyreleased"))
-			}
-			n.Push(t.Runtime, stv(strings.ToLower(sdl.GetScancodeName(e.Keysym.Scancode))))
-		default:
-			goto poll
-	}
+// set when a wake is queued, so other goroutines (process output, process
+// exits, dirmonitor changes) wake wait_event only once until it's seen
+var wakePending int32
 
-	return n, nil
+const (
+	wakeCodeWake = iota
+	wakeCodeProcessExited
+)
+
+type processExit struct{
+	pid int
+	status int64
+	limit string
 }
 
-func systemWaitEvent(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	wait := func(t int) sdl.Event {
-		return sdl.WaitEvent()
+var processExits []processExit
+var processExitsMu sync.Mutex
+
+// postWakeEvent wakes the event loop. It's safe to call from any goroutine,
+// and only one wake is queued at a time so chatty sources don't flood sdl.
+func postWakeEvent() {
+	if atomic.CompareAndSwapInt32(&wakePending, 0, 1) {
+		pushWakeEvent(wakeCodeWake)
 	}
+}
 
-	var timeout float64
-	if err := c.Check1Arg(); err == nil {
-		//fmt.Println("not indefinite wait")
-		wait = sdl.WaitEventTimeout
-		timeout, err = c.FloatArg(0)
-		if err != nil {
-			return nil, err
-		}
+// postProcessExited queues a processexited event for poll_event.
+func postProcessExited(pid int, status int64, limit string) {
+	processExitsMu.Lock()
+	processExits = append(processExits, processExit{pid, status, limit})
+	processExitsMu.Unlock()
+
+	pushWakeEvent(wakeCodeProcessExited)
+}
+
+// nextProcessExit takes the oldest queued exit, if there is one.
+func nextProcessExit() (processExit, bool) {
+	processExitsMu.Lock()
+	defer processExitsMu.Unlock()
+	if len(processExits) == 0 {
+		return processExit{}, false
 	}
+	exit := processExits[0]
+	processExits = processExits[1:]
 
-	ev := wait(int(time.Duration(timeout) * time.Second))
-	return c.PushingNext1(t.Runtime, rt.BoolValue(ev != nil)), nil
+	return exit, true
+}
+
+// pushProcessExit pushes a processexited event for poll_event to return.
+func pushProcessExit(t *rt.Thread, n rt.Cont, exit processExit) {
+	n.Push(t.Runtime, stv("processexited"))
+	n.Push(t.Runtime, itv(int64(exit.pid)))
+	n.Push(t.Runtime, itv(exit.status))
+	if exit.limit != "" {
+		n.Push(t.Runtime, stv(exit.limit))
+	}
 }
 
 func systemSetCursor(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	// TODO: set passed cursor
-	curs := sdl.CreateSystemCursor(sdl.SYSTEM_CURSOR_ARROW)
-	sdl.SetCursor(curs)
+	setCursor()
 	return c.Next(), nil
 }
 
@@ -184,7 +154,7 @@ func systemSetTitle(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	wnd.Window.SetTitle(title)
+	setWindowTitle(title)
 	return c.Next(), nil
 }
 
@@ -194,20 +164,7 @@ func systemSetWindowMode(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 }
 
 func systemGetWindowMode(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	flags := wnd.Window.GetFlags()
-	var mode string
-	switch {
-		case flags & sdl.WINDOW_FULLSCREEN_DESKTOP == 0:
-			mode = "fullscreen"
-		case flags & sdl.WINDOW_MINIMIZED == 0:
-			mode = "minimized"
-		case flags & sdl.WINDOW_MAXIMIZED == 0:
-			mode = "maximized"
-		default:
-			mode = "normal"
-	}
-
-	return c.PushingNext1(t.Runtime, rt.StringValue(mode)), nil
+	return c.PushingNext1(t.Runtime, rt.StringValue(windowMode())), nil
 }
 
 func systemSetBordered(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
@@ -227,8 +184,7 @@ func systemWindowHitTest(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 }
 
 func systemGetWindowSize(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	w, h := wnd.Window.GetSize()
-	x, y := wnd.Window.GetPosition()
+	w, h, x, y := windowSize()
 
 	return c.PushingNext(t.Runtime, rt.IntValue(int64(w)), rt.IntValue(int64(h)), rt.IntValue(int64(x)), rt.IntValue(int64(y))), nil
 }
@@ -251,8 +207,7 @@ func systemSetWindowSize(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	wnd.Window.SetSize(int32(w), int32(h))
-	wnd.Window.SetPosition(int32(x), int32(y))
+	setWindowSize(int(w), int(h), int(x), int(y))
 
 	return c.Next(), nil
 }
@@ -279,8 +234,7 @@ func systemShowFatalError(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	// really: what are we gonna do when we move to just gl ...
-	if err := sdl.ShowSimpleMessageBox(sdl.MESSAGEBOX_ERROR, title, message, wnd.Window); err != nil {
+	if err := showFatalError(title, message); err != nil {
 		return nil, err
 	}
 
@@ -380,12 +334,16 @@ func systemGetFileInfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error){
 
 // get_process_id
 
+// startTime is when feza was launched, for get_time
+var startTime = time.Now()
+
 func systemGetTime(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
-	// "Get amount of iterations since the application was launched, also known as SDL_GetPerformanceCounter() / SDL_GetPerformanceFrequency()"
-	// what is this going to be when we use just gl ...
-	time := sdl.GetPerformanceCounter() / sdl.GetPerformanceFrequency()
+	// seconds since launch, like SDL_GetPerformanceCounter() /
+	// SDL_GetPerformanceFrequency() but from go's monotonic clock so it
+	// doesn't need sdl
+	secs := time.Since(startTime).Seconds()
 
-	return c.PushingNext1(t.Runtime, rt.IntValue(int64(time))), nil
+	return c.PushingNext1(t.Runtime, rt.FloatValue(secs)), nil
 }
 
 func systemSleep(r *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
@@ -399,6 +357,152 @@ func systemSleep(r *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 }
 
 // exec
+func systemExec(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	command, err := c.StringArg(0)
+	if err != nil {
+		return nil, err
+	}
+
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	// own session so it outlives us and doesn't get our signals,
+	// and nil stdio means /dev/null
+	cmd := exec.Command(shell, "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// reap it whenever it exits so it doesn't hang around as a zombie
+	go cmd.Wait()
+
+	return c.Next(), nil
+}
+
+// get_login_env
+var loginEnv []string
+var loginEnvErr error
+var loginEnvOnce sync.Once
+
+// marks where the env output starts, in case rc files print something
+const loginEnvMarker = "__FEZA_LOGIN_ENV__"
+
+// getLoginEnv returns the environment of the user's login shell. When
+// launched from a desktop menu our own environment misses everything set up
+// in shell rc files (PATH additions, version manager shims and such).
+// The shell only gets run once, later calls return the cached result.
+func getLoginEnv() ([]string, error) {
+	loginEnvOnce.Do(func() {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+		defer cancel()
+
+		// a lot of setups only touch PATH in .bashrc/.zshrc, which takes
+		// -i, but interactive shells misbehave without a terminal to use
+		args := []string{"-l"}
+		if hasTerminal() {
+			args = append(args, "-i")
+		}
+		args = append(args, "-c", "printf '" + loginEnvMarker + "'; env -0")
+		cmd := exec.CommandContext(ctx, shell, args...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		out, err := loginShellOutput(cmd)
+		if err != nil {
+			loginEnvErr = fmt.Errorf("could not get login environment from %s: %w", shell, err)
+			return
+		}
+
+		idx := bytes.LastIndex(out, []byte(loginEnvMarker))
+		if idx == -1 {
+			loginEnvErr = fmt.Errorf("could not get login environment from %s", shell)
+			return
+		}
+		out = out[idx + len(loginEnvMarker):]
+
+		sep := []byte{0}
+		if bytes.IndexByte(out, 0) == -1 {
+			// env without -0 (not gnu), values with newlines will be off
+			sep = []byte{'\n'}
+		}
+		for _, kv := range bytes.Split(out, sep) {
+			if bytes.IndexByte(kv, '=') > 0 {
+				loginEnv = append(loginEnv, string(kv))
+			}
+		}
+	})
+
+	return loginEnv, loginEnvErr
+}
+
+// loginEnvDrain is how long the shell's stdout is still read after it has
+// exited. anything it started in the background (ssh-agent, gpg-agent..)
+// can hold on to the pipe forever, so we can't wait for it to close.
+const loginEnvDrain = 200 * time.Millisecond
+
+// loginShellOutput runs cmd and returns what it wrote to stdout, without
+// waiting on children that keep stdout open like cmd.Output would.
+func loginShellOutput(cmd *exec.Cmd) ([]byte, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+
+	cmd.Stdout = pw
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	read := make(chan struct{})
+	go func() {
+		out.ReadFrom(pr)
+		close(read)
+	}()
+
+	err = cmd.Wait()
+	pr.SetReadDeadline(time.Now().Add(loginEnvDrain))
+	<-read
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// hasTerminal is whether we have a controlling terminal.
+func hasTerminal() bool {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false
+	}
+	tty.Close()
+
+	return true
+}
+
+func systemGetLoginEnv(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	env, err := getLoginEnv()
+	if err != nil {
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.StringValue(err.Error())), nil
+	}
+
+	envTbl := rt.NewTable()
+	for _, kv := range env {
+		idx := strings.IndexByte(kv, '=')
+		envTbl.Set(rt.StringValue(kv[:idx]), rt.StringValue(kv[idx + 1:]))
+	}
+
+	return c.PushingNext1(t.Runtime, rt.TableValue(envTbl)), nil
+}
 
 // fuzzy_match
 
@@ -409,7 +513,7 @@ func systemSetWindowOpacity(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		return nil, err
 	}
 
-	wnd.Window.SetWindowOpacity(float32(opacity))
+	setWindowOpacity(opacity)
 
 	return c.Next(), nil
 }
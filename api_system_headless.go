@@ -0,0 +1,95 @@
+//go:build headless
+// +build headless
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// wakeups is what pushWakeEvent signals without sdl's event queue, one
+// wake is enough for wait_event to return
+var wakeups = make(chan struct{}, 1)
+
+// setupWakeEvents has nothing to set up without sdl.
+func setupWakeEvents() {
+}
+
+func pushWakeEvent(code int32) {
+	select {
+		case wakeups <- struct{}{}:
+		default:
+	}
+}
+
+// poll_event, without a window the only events are processes exiting
+func systemPollEvent(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	n := c.Next()
+	if exit, ok := nextProcessExit(); ok {
+		pushProcessExit(t, n, exit)
+	}
+
+	return n, nil
+}
+
+func systemWaitEvent(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	// a nil channel never fires, so no timeout waits for good
+	var expired <-chan time.Time
+	if err := c.Check1Arg(); err == nil {
+		timeout, err := c.FloatArg(0)
+		if err != nil {
+			return nil, err
+		}
+		expired = time.After(time.Duration(timeout * float64(time.Second)))
+	}
+
+	processExitsMu.Lock()
+	pending := len(processExits) != 0
+	processExitsMu.Unlock()
+
+	event := pending
+	if !pending {
+		select {
+			case <-wakeups:
+				event = true
+			case <-expired:
+		}
+	}
+	if event {
+		atomic.StoreInt32(&wakePending, 0)
+	}
+
+	return c.PushingNext1(t.Runtime, rt.BoolValue(event)), nil
+}
+
+func setCursor() {
+}
+
+func setWindowTitle(title string) {
+}
+
+func windowMode() string {
+	return "normal"
+}
+
+func windowSize() (w, h, x, y int) {
+	// nothing to position without a window
+	w, h = rend.size()
+	return w, h, 0, 0
+}
+
+func setWindowSize(w, h, x, y int) {
+}
+
+func showFatalError(title, message string) error {
+	_, err := fmt.Fprintf(os.Stderr, "%s\n%s\n", title, message)
+	return err
+}
+
+func setWindowOpacity(opacity float64) {
+}
@@ -0,0 +1,233 @@
+//go:build !headless
+// +build !headless
+
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	rt "github.com/arnodel/golua/runtime"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// sdl user event type that pushWakeEvent pushes to wake up wait_event
+var wakeEventType uint32
+
+// setupWakeEvents registers our user event, it must be called after
+// sdl has been initialized.
+func setupWakeEvents() {
+	wakeEventType = sdl.RegisterEvents(1)
+}
+
+func pushWakeEvent(code int32) {
+	if wakeEventType == 0 || wakeEventType == ^uint32(0) {
+		return
+	}
+
+	// sdl copies a whole SDL_Event, so give it one instead of the smaller
+	// UserEvent struct
+	var ev sdl.CEvent
+	ue := (*sdl.UserEvent)(unsafe.Pointer(&ev))
+	ue.Type = wakeEventType
+	ue.Code = code
+	sdl.PushEvent(ue)
+}
+
+// pendingEvent is an event wait_event took off the queue, poll_event hands
+// it out before anything newer.
+var pendingEvent sdl.Event
+
+func nextEvent() sdl.Event {
+	if ev := pendingEvent; ev != nil {
+		pendingEvent = nil
+		return ev
+	}
+
+	return sdl.PollEvent()
+}
+
+func isWakeEvent(ev sdl.Event) bool {
+	e, ok := ev.(*sdl.UserEvent)
+	return ok && e.Type == wakeEventType && e.Code == wakeCodeWake
+}
+
+// poll_event returns the next event, or nothing if there's none so the
+// main loop can go on and wait instead
+func systemPollEvent(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	n := c.Next()
+poll:
+	event := nextEvent()
+	if event == nil {
+		return n, nil
+	}
+
+	switch e := event.(type) {
+		case *sdl.QuitEvent:
+			n.Push(t.Runtime, stv("quit"))
+		// Window Events
+		case *sdl.WindowEvent:
+			switch e.Event {
+				case sdl.WINDOWEVENT_EXPOSED:
+					// what's on screen may be gone, so the next frame
+					// can't skip what didn't change
+					invalidateRenCache()
+					n.Push(t.Runtime, stv("exposed"))
+				case sdl.WINDOWEVENT_RESTORED:
+					invalidateRenCache()
+					n.Push(t.Runtime, stv("restored"))
+				case sdl.WINDOWEVENT_RESIZED:
+					w := e.Data1
+					h := e.Data2
+					n.Push(t.Runtime, stv("resized"))
+					n.Push(t.Runtime, itv(int64(w)))
+					n.Push(t.Runtime, itv(int64(h)))
+				default:
+					goto poll
+			}
+		// Mouse Events
+		case *sdl.MouseButtonEvent:
+			typ := "mousereleased"
+			if e.Type == sdl.MOUSEBUTTONDOWN {
+				typ = "mousepressed"
+			}
+			n.Push(t.Runtime, stv(typ))
+	
+			var buttonName string
+			switch e.Button {
+				case sdl.BUTTON_LEFT: buttonName = "left"
+				case sdl.BUTTON_MIDDLE: buttonName = "middle"
+				case sdl.BUTTON_RIGHT: buttonName = "right"
+				case sdl.BUTTON_X1: buttonName = "x"
+				case sdl.BUTTON_X2: buttonName = "y"
+			}
+			n.Push(t.Runtime, stv(buttonName))
+			n.Push(t.Runtime, itv(int64(e.X)))
+			n.Push(t.Runtime, itv(int64(e.Y)))
+			n.Push(t.Runtime, itv(int64(e.Clicks)))
+		case *sdl.MouseMotionEvent:
+			n.Push(t.Runtime, stv("mousemoved"))
+			n.Push(t.Runtime, itv(int64(e.X)))
+			n.Push(t.Runtime, itv(int64(e.Y)))
+			n.Push(t.Runtime, itv(int64(e.XRel)))
+			n.Push(t.Runtime, itv(int64(e.YRel)))
+		case *sdl.MouseWheelEvent:
+			n.Push(t.Runtime, stv("mousewheel"))
+			n.Push(t.Runtime, itv(int64(e.Y)))
+		case *sdl.DropEvent:
+			if e.Type != sdl.DROPFILE {
+				goto poll
+			}
+			// where the mouse is in the window
+			mx, my, _ := sdl.GetGlobalMouseState()
+			wx, wy := mainWindow.GetPosition()
+			n.Push(t.Runtime, stv("filedropped"))
+			n.Push(t.Runtime, stv(e.File))
+			n.Push(t.Runtime, itv(int64(mx - wx)))
+			n.Push(t.Runtime, itv(int64(my - wy)))
+		case *sdl.KeyboardEvent:
+			if e.State == sdl.PRESSED {
+				n.Push(t.Runtime, stv("keypressed"))
+			} else {
+				n.Push(t.Runtime, stv("keyreleased"))
+			}
+			n.Push(t.Runtime, stv(strings.ToLower(sdl.GetScancodeName(e.Keysym.Scancode))))
+		case *sdl.UserEvent:
+			if e.Type != wakeEventType {
+				goto poll
+			}
+			// a wake has nothing to say, returning nothing lets the
+			// main loop run its threads to pick up what woke it
+			if e.Code != wakeCodeProcessExited {
+				atomic.StoreInt32(&wakePending, 0)
+				return n, nil
+			}
+
+			exit, ok := nextProcessExit()
+			if !ok {
+				return n, nil
+			}
+			pushProcessExit(t, n, exit)
+		default:
+			goto poll
+	}
+
+	return n, nil
+}
+
+func systemWaitEvent(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	wait := func(t int) sdl.Event {
+		return sdl.WaitEvent()
+	}
+
+	var timeout float64
+	if err := c.Check1Arg(); err == nil {
+		wait = sdl.WaitEventTimeout
+		timeout, err = c.FloatArg(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var ev sdl.Event
+	if pendingEvent != nil {
+		ev = pendingEvent
+	} else {
+		ev = wait(int(timeout * 1000))
+	}
+	if isWakeEvent(ev) {
+		atomic.StoreInt32(&wakePending, 0)
+		pendingEvent = nil
+	} else if ev != nil {
+		// waiting takes the event off the queue, keep it for poll_event
+		// so it stays ahead of anything that came after it
+		pendingEvent = ev
+	}
+
+	return c.PushingNext1(t.Runtime, rt.BoolValue(ev != nil)), nil
+}
+
+func setCursor() {
+	curs := sdl.CreateSystemCursor(sdl.SYSTEM_CURSOR_ARROW)
+	sdl.SetCursor(curs)
+}
+
+func setWindowTitle(title string) {
+	mainWindow.SetTitle(title)
+}
+
+func windowMode() string {
+	flags := mainWindow.GetFlags()
+	switch {
+		case flags & sdl.WINDOW_FULLSCREEN_DESKTOP == 0:
+			return "fullscreen"
+		case flags & sdl.WINDOW_MINIMIZED == 0:
+			return "minimized"
+		case flags & sdl.WINDOW_MAXIMIZED == 0:
+			return "maximized"
+		default:
+			return "normal"
+	}
+}
+
+func windowSize() (w, h, x, y int) {
+	ww, wh := mainWindow.GetSize()
+	wx, wy := mainWindow.GetPosition()
+
+	return int(ww), int(wh), int(wx), int(wy)
+}
+
+func setWindowSize(w, h, x, y int) {
+	mainWindow.SetSize(int32(w), int32(h))
+	mainWindow.SetPosition(int32(x), int32(y))
+}
+
+func showFatalError(title, message string) error {
+	// really: what are we gonna do when we move to just gl ...
+	return sdl.ShowSimpleMessageBox(sdl.MESSAGEBOX_ERROR, title, message, mainWindow)
+}
+
+func setWindowOpacity(opacity float64) {
+	mainWindow.SetWindowOpacity(float32(opacity))
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/bidi"
+)
+
+// bidiRun is a piece of a line in one direction, in byte offsets.
+type bidiRun struct{
+	start, end int
+	rtl bool
+	level int
+}
+
+// strongClass is the direction a rune forces, and false if it's weak or neutral.
+func strongClass(r rune) (rtl bool, ok bool) {
+	p, _ := bidi.LookupRune(r)
+	switch p.Class() {
+		case bidi.R, bidi.AL: return true, true
+		case bidi.L: return false, true
+	}
+
+	return false, false
+}
+
+// hasRTL says if there's anything right to left in text, so lines without
+// any don't go through the whole algorithm.
+func hasRTL(text string) bool {
+	for _, r := range text {
+		if r < 0x590 {
+			continue
+		}
+		if rtl, ok := strongClass(r); ok && rtl {
+			return true
+		}
+	}
+
+	return false
+}
+
+// paragraphRTL is the direction of a paragraph, from its first strong rune.
+func paragraphRTL(text string) bool {
+	for _, r := range text {
+		if rtl, ok := strongClass(r); ok {
+			return rtl
+		}
+		if isParagraphSeparator(r) {
+			break
+		}
+	}
+
+	return false
+}
+
+func isParagraphSeparator(r rune) bool {
+	p, _ := bidi.LookupRune(r)
+	return p.Class() == bidi.B
+}
+
+// paragraphEnd is where the paragraph starting at start ends, after its
+// separator. \r\n counts as one.
+func paragraphEnd(text string, start int) int {
+	for i, r := range text[start:] {
+		if !isParagraphSeparator(r) {
+			continue
+		}
+		end := start + i + utf8.RuneLen(r)
+		if r == '\r' && end < len(text) && text[end] == '\n' {
+			end++
+		}
+		return end
+	}
+
+	return len(text)
+}
+
+// bidiRuns splits text into runs of one direction with the unicode bidi
+// algorithm and returns them in visual order, left to right. every
+// paragraph gets its own direction and is ordered on its own, they stay in
+// the order they come in. rtl is the direction of the first one.
+func bidiRuns(text string) (runs []bidiRun, rtl bool) {
+	rtl = paragraphRTL(text)
+	for start := 0; start < len(text); {
+		end := paragraphEnd(text, start)
+		for _, r := range paragraphRuns(text[start:end]) {
+			r.start += start
+			r.end += start
+			runs = append(runs, r)
+		}
+		start = end
+	}
+
+	return runs, rtl
+}
+
+// paragraphRuns is bidiRuns for a single paragraph, which may end with its
+// separator.
+func paragraphRuns(text string) (runs []bidiRun) {
+	rtl := paragraphRTL(text)
+	base := 0
+	if rtl {
+		base = 1
+	}
+	if !hasRTL(text) {
+		return []bidiRun{{0, len(text), false, 0}}
+	}
+
+	// the separator is at the paragraph's level, which puts it at the
+	// end the paragraph reads towards
+	n := len(text)
+	if r, size := utf8.DecodeLastRuneInString(text); isParagraphSeparator(r) {
+		n -= size
+		if r == '\n' && n > 0 && text[n - 1] == '\r' {
+			n--
+		}
+	}
+
+	// rune offsets to byte ones
+	offsets := make([]int, 0, n + 1)
+	for i := range text[:n] {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, n)
+
+	var p bidi.Paragraph
+	p.SetString(text[:n])
+	order, err := p.Order()
+	if err != nil || order.NumRuns() == 0 {
+		return []bidiRun{{0, len(text), rtl, base}}
+	}
+
+	// the ordering only knows directions, levels are worked out from them:
+	// left to right text in a right to left line goes on top, and so do
+	// numbers after right to left text in a left to right line.
+	prevRTL := false
+	for i := 0; i < order.NumRuns(); i++ {
+		run := order.Run(i)
+		start, end := run.Pos()
+		r := bidiRun{
+			start: offsets[start],
+			end: offsets[end + 1],
+			rtl: run.Direction() == bidi.RightToLeft,
+		}
+
+		switch {
+			case r.rtl:
+				r.level = 1
+				runs = append(runs, r)
+			case rtl:
+				r.level = 2
+				runs = append(runs, r)
+			default:
+				runs = append(runs, splitNumbers(text, r, prevRTL)...)
+		}
+
+		for _, c := range text[r.start:r.end] {
+			if crtl, ok := strongClass(c); ok {
+				prevRTL = crtl
+			}
+		}
+	}
+	if n < len(text) {
+		// kept in logical order, so \r\n stays as it is
+		runs = append(runs, bidiRun{n, len(text), false, base})
+	}
+
+	// reverse runs from the highest level down to the lowest odd one
+	maxLevel := 0
+	for _, r := range runs {
+		if r.level > maxLevel {
+			maxLevel = r.level
+		}
+	}
+	for lvl := maxLevel; lvl >= 1; lvl-- {
+		for i := 0; i < len(runs); {
+			if runs[i].level < lvl {
+				i++
+				continue
+			}
+			j := i
+			for j < len(runs) && runs[j].level >= lvl {
+				j++
+			}
+			for a, b := i, j - 1; a < b; a, b = a + 1, b - 1 {
+				runs[a], runs[b] = runs[b], runs[a]
+			}
+			i = j
+		}
+	}
+
+	return runs
+}
+
+// splitNumbers splits a left to right run of a left to right line where
+// numbers that come after right to left text go a level up, as they're
+// part of that text.
+func splitNumbers(text string, run bidiRun, prevRTL bool) []bidiRun {
+	type class int
+	const (
+		neutral class = iota
+		strong
+		number
+	)
+
+	var offsets []int
+	var classes []class
+	for i, c := range text[run.start:run.end] {
+		cl := neutral
+		p, _ := bidi.LookupRune(c)
+		switch p.Class() {
+			case bidi.L, bidi.R, bidi.AL:
+				cl = strong
+				prevRTL = p.Class() != bidi.L
+			case bidi.EN, bidi.AN:
+				if prevRTL {
+					cl = number
+				}
+		}
+		offsets = append(offsets, run.start + i)
+		classes = append(classes, cl)
+	}
+	offsets = append(offsets, run.end)
+
+	levels := make([]int, len(classes))
+	last := -1 // the last number, if nothing strong came after it
+	for i, cl := range classes {
+		switch cl {
+			case number:
+				// neutrals between numbers go with them
+				for k := last + 1; last >= 0 && k < i; k++ {
+					levels[k] = 2
+				}
+				levels[i] = 2
+				last = i
+			case strong:
+				last = -1
+		}
+	}
+
+	var runs []bidiRun
+	for i := range levels {
+		if i == 0 || levels[i] != levels[i - 1] {
+			runs = append(runs, bidiRun{offsets[i], 0, false, levels[i]})
+		}
+		runs[len(runs) - 1].end = offsets[i + 1]
+	}
+
+	return runs
+}
+
+// bidiOrder returns the byte offsets of the characters of a line in the
+// order they're shown, left to right.
+func bidiOrder(text string, runs []bidiRun) []int {
+	visual := make([]int, 0, utf8.RuneCountInString(text))
+	for _, r := range runs {
+		start := len(visual)
+		for i := range text[r.start:r.end] {
+			visual = append(visual, r.start + i)
+		}
+		if r.rtl {
+			v := visual[start:]
+			for a, b := 0, len(v) - 1; a < b; a, b = a + 1, b - 1 {
+				v[a], v[b] = v[b], v[a]
+			}
+		}
+	}
+
+	return visual
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// visualText is text with its characters in the order they're shown.
+func visualText(text string) string {
+	runs, _ := bidiRuns(text)
+	var b strings.Builder
+	for _, off := range bidiOrder(text, runs) {
+		r, _ := utf8.DecodeRuneInString(text[off:])
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func TestBidiOrder(t *testing.T) {
+	tests := []struct{
+		text, want string
+		rtl bool
+	}{
+		{"abc def", "abc def", false},
+		{"abc שלום def", "abc םולש def", false},
+		{"שלום abc עולם", "םלוע abc םולש", true},
+		{"-- שלום עולם", "םלוע םולש --", true},
+		{"abc שלום עולם", "abc םלוע םולש", false},
+		// every paragraph has its own direction and they stay in order
+		{"שלום\nabc", "\nםולשabc", true},
+		{"abc\nשלום", "abc\nםולש", false},
+		{"שלום\r\nabc def", "\r\nםולשabc def", true},
+		{"abc\nשלום\nעולם", "abc\n\nםולשםלוע", false},
+	}
+	for _, test := range tests {
+		if got := visualText(test.text); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+		if _, rtl := bidiRuns(test.text); rtl != test.rtl {
+			t.Errorf("%q: rtl is %v", test.text, rtl)
+		}
+	}
+}
+
+func TestBidiRunsCoverText(t *testing.T) {
+	for _, text := range []string{"", "\n", "שלום\n", "a\nb\n", "abc שלום 123\nעולם"} {
+		runs, _ := bidiRuns(text)
+		seen := make([]bool, len(text))
+		for _, r := range runs {
+			for i := r.start; i < r.end; i++ {
+				if seen[i] {
+					t.Errorf("%q: byte %d is in more than one run", text, i)
+				}
+				seen[i] = true
+			}
+		}
+		for i, ok := range seen {
+			if !ok {
+				t.Errorf("%q: byte %d is in no run", text, i)
+			}
+		}
+	}
+}
@@ -1342,7 +1342,6 @@ function core.step()
   local did_keymap = false
 
   local type, a,b,c,d = system.poll_event()
-    print(type, a, b, c, d)
     if type == "textinput" and did_keymap then
       did_keymap = false
     elseif type == "mousemoved" then
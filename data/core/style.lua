@@ -27,11 +27,10 @@ style.margin = {
 -- - for antialiasing: grayscale, subpixel
 -- - for hinting: none, slight, full
 --
--- The defaults values are antialiasing subpixel and hinting slight for optimal visualization
--- on ordinary LCD monitor with RGB patterns.
---
--- On High DPI monitor or non RGB monitor you may consider using antialiasing grayscale instead.
--- The antialiasing grayscale with full hinting is interesting for crisp font rendering.
+-- The defaults values are antialiasing grayscale and hinting slight. There's no LCD
+-- rendering, subpixel is accepted but drawn the same as grayscale, and hinting only
+-- rounds the font metrics, the glyph outlines aren't hinted.
+-- Full hinting places glyphs at whole pixels, which is interesting for crisp font rendering.
 style.font = renderer.font.load(DATADIR .. "/fonts/FiraSans-Regular.ttf", 15 * SCALE)
 style.big_font = style.font:copy(46 * SCALE)
 style.icon_font = renderer.font.load(DATADIR .. "/fonts/icons.ttf", 16 * SCALE, {antialiasing="grayscale", hinting="full"})
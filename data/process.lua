@@ -54,6 +54,7 @@ process.STREAM_STDERR = 2
 process.WAIT_INFINITE = -1
 
 ---Instruct process:wait() to wait until the deadline given on process:start()
+---has passed and the process was killed for it, so it returns the exit status.
 ---@type integer
 process.WAIT_DEADLINE = -2
 
@@ -106,12 +107,20 @@ process.REDIRECT_STDOUT = 4
 ---
 --- Options that can be passed to process.start()
 ---@class process.options
----@field public timeout number
+---@field public timeout number Milliseconds after which the process is killed,
+---also the deadline for process:wait(process.WAIT_DEADLINE).
+---@field public max_memory integer Data memory limit in bytes (Linux only).
+---@field public max_cpu_seconds integer CPU time limit in seconds (Linux only).
+---@field public nice integer Scheduling priority, as in nice(1) (Linux only).
+---The limits and priority are set right after the process starts, so they
+---miss whatever it spawns in its first moments.
 ---@field public cwd string
 ---@field public stdin process.redirecttype
 ---@field public stdout process.redirecttype
 ---@field public stderr process.redirecttype
 ---@field public env table<string, string>
+---@field public pty boolean Run the process under a pseudo terminal, stdout and
+---stderr are both read from the stdout stream.
 
 ---
 ---Create and start a new process
@@ -201,7 +210,8 @@ function process:close_stream(stream) end
 ---if 0, the function will only check if process is running without waiting.
 ---
 ---@return integer | nil exit_status The process exit status or nil on error
----@return string errmsg
+---@return string errmsg Or the limit that killed the process if any,
+---one of "timeout", "cpu" or "memory".
 ---@return process.errortype | integer errcode
 function process:wait(timeout) end
 
@@ -225,6 +235,8 @@ function process:kill() end
 ---Get the exit code of the process or nil if still running.
 ---
 ---@return number | nil
+---@return string? limit The limit that killed the process if any,
+---one of "timeout", "cpu" or "memory".
 function process:returncode() end
 
 ---
@@ -233,5 +245,16 @@ function process:returncode() end
 ---@return boolean
 function process:running() end
 
+---
+---Resize the pseudo terminal of a process started with the pty option.
+---
+---@param cols integer
+---@param rows integer
+---
+---@return boolean | nil
+---@return string errmsg
+---@return process.errortype | integer errcode
+function process:resize(cols, rows) end
+
 
 return process
@@ -2,6 +2,11 @@
 
 ---
 ---Provides the base functionality for regular expressions matching.
+---
+---Offsets taken and returned are always 1-based byte offsets. Subjects are
+---read as UTF-8 where each byte of an invalid sequence is matched as a
+---U+FFFD character, unless the pattern was compiled with the "b" modifier,
+---which matches every byte as the character with the same value.
 ---@class regex
 regex = {}
 
@@ -30,10 +35,21 @@ regex.NOTEMPTY = 0x00000004
 ---@type integer
 regex.NOTEMPTY_ATSTART = 0x00000008
 
+---Error code for a match that was aborted because it took too long, which
+---happens with patterns that backtrack catastrophically.
+---@type integer
+regex.ERROR_MATCHLIMIT = -47
+
+---Error code for any other failure of the matching engine.
+---@type integer
+regex.ERROR_INTERNAL = -44
+
 ---@alias regex.modifiers
 ---| "i"  # Case insesitive matching
 ---| "m"  # Multiline matching
 ---| "s"  # Match all characters with dot (.) metacharacter even new lines
+---| "x"  # Ignore whitespace and # comments in the pattern
+---| "b"  # Match raw bytes instead of UTF-8 characters
 
 ---
 ---Compiles a regular expression pattern that can be used to search in strings.
@@ -54,6 +70,8 @@ function regex.compile(pattern, options) end
 ---regex.NOTBOL | regex.NOTEMPTY
 ---
 ---@return integer? ... List of offsets where a match was found.
+---If matching failed, nil is returned instead, followed by an error message
+---and one of the regex.ERROR_* codes.
 function regex:cmatch(subject, offset, options) end
 
 ---
@@ -75,11 +93,24 @@ function regex:cmatch(subject, offset, options) end
 ---@return fun():string, ...
 function regex.gmatch(pattern, subject, offset) end
 
+---
+---Looks for the first match on the subject like `string.match`, returning
+---the captured strings, or the whole match if the pattern has no captures.
+---Empty captures return their offset instead.
+---
+---@param subject string
+---@param offset? integer
+---@param options? integer A bit field of matching options.
+---
+---@return (string|integer)? ...
+function regex:match(subject, offset, options) end
+
 ---
 ---Replaces the matched pattern globally on the subject with the given
 ---replacement, supports named captures ((?'name'<pattern>), ${name}) and
----$[1-9][0-9]* substitutions. Raises an error when failing to compile the
----pattern or by a substitution mistake.
+---$[1-9][0-9]*, ${n} and \[1-9][0-9]* substitutions, with $$ and \\ for
+---a literal $ and \. Raises an error when failing to compile the pattern or
+---by a substitution mistake.
 ---
 ---@param pattern regex|string
 ---@param subject string
@@ -18,8 +18,8 @@ renderer = {}
 ---
 ---Represent options that affect a font's rendering.
 ---@class renderer.fontoptions
----@field public antialiasing "none" | "grayscale" | "subpixel"
----@field public hinting "slight" | "none" | "full"
+---@field public antialiasing "none" | "grayscale" | "subpixel" LCD rendering isn't supported, so "subpixel" is the same as "grayscale".
+---@field public hinting "slight" | "none" | "full" Glyph outlines aren't hinted, only the font metrics are rounded to whole pixels.
 ---@field public bold boolean
 ---@field public italic boolean
 ---@field public underline boolean
@@ -141,8 +141,35 @@ function renderer.set_clip_rect(x, y, width, height) end
 ---@param color renderer.color
 function renderer.draw_rect(x, y, width, height, color) end
 
+---
+---A piece of a line going in one direction, from byte offset 1 to 2.
+---@class renderer.bidirun
+---@field public [1] integer
+---@field public [2] integer
+---@field public rtl boolean
+
+---
+---How a line is laid out by the Unicode Bidirectional Algorithm.
+---@class renderer.bidi
+---@field public rtl boolean If the line as a whole goes right to left.
+---@field public runs renderer.bidirun[] The runs in visual order, left to right.
+---@field public visual_to_logical integer[] Byte offset of each character, in visual order.
+---@field public logical_to_visual table<integer, integer> Visual position of the character at each byte offset.
+
+---
+---Get the visual order of a line with mixed left to right and right to left
+---text. Every paragraph (text after a separator like "\n") gets its own
+---direction. Lines are laid out like this by draw_text.
+---
+---@param text string
+---
+---@return renderer.bidi
+function renderer.bidi(text) end
+
 ---
 ---Draw text and return the x coordinate where the text finished drawing.
+---Text drawn from where the last draw_text ended, with the same font and y,
+---is laid out together with it as one line.
 ---
 ---@param font renderer.font
 ---@param text string
@@ -284,6 +284,17 @@ function system.sleep(seconds) end
 ---@param command string The command to execute.
 function system.exec(command) end
 
+---
+---Get the environment of the user's login shell, which has the PATH and
+---other variables set up by shell rc files even when the editor was started
+---from a desktop launcher. The shell is only run once, the result is cached.
+---When started with --login-env, this is also the environment processes
+---started with process.start() get by default.
+---
+---@return table<string, string> | nil env
+---@return string errmsg
+function system.get_login_env() end
+
 ---
 ---Generates a matching score depending on how well the value of the
 ---given needle compares to that of the value in the haystack.
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"sort"
+
+	tsfont "github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/language"
+	xfont "golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// fontFile is a loaded font file. sfnt is used for metrics and outlines,
+// the typesetting one for shaping.
+type fontFile struct{
+	path string
+	sf *sfnt.Font
+	ts *tsfont.Face
+	buf sfnt.Buffer
+	glyphs map[rune]sfnt.GlyphIndex // 0 for runes it doesn't have
+}
+
+// files by path, so copies and reloads of a font share the parsed file
+var fontFiles = map[string]*fontFile{}
+
+func loadFontFile(path string) (*fontFile, error) {
+	if file, ok := fontFiles[path]; ok {
+		return file, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sf, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := tsfont.ParseTTF(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	file := &fontFile{
+		path: path,
+		sf: sf,
+		ts: ts,
+		glyphs: map[rune]sfnt.GlyphIndex{},
+	}
+	fontFiles[path] = file
+
+	return file, nil
+}
+
+type fontAntialiasing int
+
+const (
+	fontAntialiasingNone fontAntialiasing = iota
+	fontAntialiasingGrayscale
+)
+
+// fontHinting only changes metrics: sfnt can round the line metrics and
+// advances for a hinting mode, but it has no hinter for the outlines
+// themselves, so glyph shapes are never snapped to the pixel grid.
+// slight rounds the vertical metrics, full the advances as well.
+type fontHinting int
+
+const (
+	fontHintingNone fontHinting = iota
+	fontHintingSlight
+	fontHintingFull
+)
+
+// fontOptions are the renderer.fontoptions of a face.
+type fontOptions struct{
+	antialiasing fontAntialiasing
+	hinting fontHinting
+	bold bool
+	italic bool
+	underline bool
+	strikethrough bool
+	smoothing bool
+}
+
+// same as lite xl
+var defaultFontOptions = fontOptions{
+	antialiasing: fontAntialiasingGrayscale,
+	hinting: fontHintingSlight,
+}
+
+// how much italic text leans, the same shear freetype uses
+const fontItalicShear = 0.2126
+
+// fontFace is a font file at a size.
+type fontFace struct{
+	file *fontFile
+	opts fontOptions
+	size float64
+	height float64 // line height, ascent + descent + line gap
+	ascent float64
+	// where decorations go, relative to the baseline
+	underline, strikethrough, lineThickness float64
+	cache *glyphCache
+}
+
+func newFontFace(file *fontFile, size float64, opts fontOptions) *fontFace {
+	face := &fontFace{
+		file: file,
+		opts: opts,
+	}
+	face.setSize(size)
+
+	return face
+}
+
+func (f *fontFace) hinting() xfont.Hinting {
+	switch f.opts.hinting {
+		case fontHintingSlight: return xfont.HintingVertical
+		case fontHintingFull: return xfont.HintingFull
+	}
+
+	return xfont.HintingNone
+}
+
+// emboldening is how much wider bold and smoothing make glyphs.
+func (f *fontFace) emboldening() float64 {
+	var x float64
+	if f.opts.bold {
+		x += f.size / 24
+	}
+	if f.opts.smoothing {
+		x += 0.5
+	}
+
+	return x
+}
+
+func (f *fontFace) ppem() fixed.Int26_6 {
+	return fixed.Int26_6(f.size * 64)
+}
+
+func (f *fontFace) setSize(size float64) {
+	f.size = size
+	f.cache = newGlyphCache()
+
+	m, err := f.file.sf.Metrics(&f.file.buf, f.ppem(), f.hinting())
+	if err != nil {
+		// shouldn't happen once the font parsed, but be sensible about it
+		m = xfont.Metrics{
+			Height: f.ppem(),
+			Ascent: f.ppem(),
+		}
+	}
+	f.height = float64(m.Height) / 64
+	f.ascent = float64(m.Ascent) / 64
+	if f.opts.hinting != fontHintingNone {
+		// sfnt only rounds these for full hinting
+		f.height, f.ascent = math.Round(f.height), math.Round(f.ascent)
+	}
+
+	f.lineThickness = math.Max(1, math.Round(size / 14))
+	f.underline = math.Round(size / 10)
+	if post := f.file.sf.PostTable(); post != nil && post.UnderlineThickness > 0 {
+		scale := size / float64(f.file.sf.UnitsPerEm())
+		f.lineThickness = math.Max(1, math.Round(float64(post.UnderlineThickness) * scale))
+		f.underline = math.Round(float64(-post.UnderlinePosition) * scale)
+	}
+
+	xHeight := float64(m.XHeight) / 64
+	if xHeight <= 0 {
+		xHeight = f.ascent / 2
+	}
+	f.strikethrough = -math.Round(xHeight / 2)
+}
+
+// glyph returns the glyph for a rune, and false if the font doesn't have it.
+func (f *fontFace) glyph(r rune) (sfnt.GlyphIndex, bool) {
+	idx, ok := f.file.glyphs[r]
+	if !ok {
+		idx, _ = f.file.sf.GlyphIndex(&f.file.buf, r)
+		f.file.glyphs[r] = idx
+	}
+
+	return idx, idx != 0
+}
+
+func (f *fontFace) glyphAdvance(idx sfnt.GlyphIndex) float64 {
+	if adv, ok := f.cache.advances[idx]; ok {
+		return adv
+	}
+
+	var adv float64
+	if a, err := f.file.sf.GlyphAdvance(&f.file.buf, idx, f.ppem(), f.hinting()); err == nil {
+		bold := f.emboldening()
+		if f.opts.hinting == fontHintingFull {
+			bold = math.Round(bold)
+		}
+		adv = float64(a) / 64 + bold
+	}
+	f.cache.advances[idx] = adv
+
+	return adv
+}
+
+// phases is how many positions within a pixel glyphs are drawn at, antialiased
+// glyphs go at thirds of a pixel unless full hinting rounds the advances.
+func (f *fontFace) phases() int {
+	if f.opts.antialiasing == fontAntialiasingGrayscale && f.opts.hinting != fontHintingFull {
+		return 3
+	}
+
+	return 1
+}
+
+// rasterize draws a glyph with its origin subx pixels into the mask's
+// origin pixel. off is where the top left of the mask goes relative to
+// that pixel.
+func (f *fontFace) rasterize(idx sfnt.GlyphIndex, subx float64) (mask *image.Alpha, off image.Point) {
+	segs, err := f.file.sf.LoadGlyph(&f.file.buf, idx, f.ppem(), nil)
+	if err != nil || len(segs) == 0 {
+		return nil, image.Point{}
+	}
+
+	shear := 0.0
+	if f.opts.italic {
+		shear = fontItalicShear
+	}
+	pt := func(p fixed.Point26_6) (float64, float64) {
+		x, y := float64(p.X) / 64, float64(p.Y) / 64
+		return x - y * shear + subx, y
+	}
+
+	// bold and smoothing draw the outline a few times shifted over
+	// itself, the rasterizer clamps the overlap
+	bold := f.emboldening()
+	boldY := 0.0
+	if f.opts.bold {
+		boldY = f.size / 24
+	}
+	var shifts [][2]float64
+	nx, ny := int(math.Ceil(bold * 2)), int(math.Ceil(boldY * 2))
+	for i := 0; i <= nx; i++ {
+		for j := 0; j <= ny; j++ {
+			var dx, dy float64
+			if nx != 0 {
+				dx = bold * float64(i) / float64(nx)
+			}
+			if ny != 0 {
+				dy = -boldY * float64(j) / float64(ny)
+			}
+			shifts = append(shifts, [2]float64{dx, dy})
+		}
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, seg := range segs {
+		n := 1
+		switch seg.Op {
+			case sfnt.SegmentOpQuadTo: n = 2
+			case sfnt.SegmentOpCubeTo: n = 3
+		}
+		for _, p := range seg.Args[:n] {
+			x, y := pt(p)
+			minX, minY = math.Min(minX, x), math.Min(minY, y)
+			maxX, maxY = math.Max(maxX, x + bold), math.Max(maxY, y)
+		}
+	}
+	minY -= boldY
+
+	off = image.Pt(int(math.Floor(minX)), int(math.Floor(minY)))
+	w := int(math.Ceil(maxX)) - off.X
+	h := int(math.Ceil(maxY)) - off.Y
+	if w <= 0 || h <= 0 {
+		return nil, image.Point{}
+	}
+
+	z := vector.NewRasterizer(w, h)
+	for _, shift := range shifts {
+		ox, oy := shift[0] - float64(off.X), shift[1] - float64(off.Y)
+		at := func(p fixed.Point26_6) (float32, float32) {
+			x, y := pt(p)
+			return float32(x + ox), float32(y + oy)
+		}
+		for _, seg := range segs {
+			switch seg.Op {
+				case sfnt.SegmentOpMoveTo:
+					z.ClosePath()
+					z.MoveTo(at(seg.Args[0]))
+				case sfnt.SegmentOpLineTo:
+					z.LineTo(at(seg.Args[0]))
+				case sfnt.SegmentOpQuadTo:
+					bx, by := at(seg.Args[0])
+					cx, cy := at(seg.Args[1])
+					z.QuadTo(bx, by, cx, cy)
+				case sfnt.SegmentOpCubeTo:
+					bx, by := at(seg.Args[0])
+					cx, cy := at(seg.Args[1])
+					dx, dy := at(seg.Args[2])
+					z.CubeTo(bx, by, cx, cy, dx, dy)
+			}
+		}
+		z.ClosePath()
+	}
+
+	mask = image.NewAlpha(image.Rect(0, 0, w, h))
+	z.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+
+	if f.opts.antialiasing == fontAntialiasingNone {
+		for i, a := range mask.Pix {
+			if a >= 0x80 {
+				mask.Pix[i] = 0xff
+			} else {
+				mask.Pix[i] = 0
+			}
+		}
+	}
+
+	return mask, off
+}
+
+// font is what lua gets from renderer.font.load: a single face, or a group
+// of them from renderer.font.group where later faces are fallbacks for
+// glyphs the earlier ones lack.
+type font struct{
+	faces []*fontFace
+	tabSize int
+}
+
+func newFont(faces ...*fontFace) *font {
+	return &font{
+		faces: faces,
+		tabSize: 2,
+	}
+}
+
+// copy makes a font with its own faces, so resizing one doesn't touch the
+// other. faces keep their options unless new ones are given.
+func (f *font) copy(size float64, opts *fontOptions) *font {
+	faces := make([]*fontFace, len(f.faces))
+	for i, face := range f.faces {
+		o := face.opts
+		if opts != nil {
+			o = *opts
+		}
+		faces[i] = newFontFace(face.file, size, o)
+	}
+
+	fnt := newFont(faces...)
+	fnt.tabSize = f.tabSize
+	return fnt
+}
+
+// the first face is the one metrics of the whole font come from
+func (f *font) size() float64 {
+	return f.faces[0].size
+}
+
+func (f *font) height() float64 {
+	return f.faces[0].height
+}
+
+func (f *font) ascent() float64 {
+	return f.faces[0].ascent
+}
+
+func (f *font) setSize(size float64) {
+	for _, face := range f.faces {
+		face.setSize(size)
+	}
+}
+
+func (f *font) paths() []string {
+	paths := make([]string, len(f.faces))
+	for i, face := range f.faces {
+		paths[i] = face.file.path
+	}
+
+	return paths
+}
+
+// faceFor picks the first face that has a glyph for r.
+func (f *font) faceFor(r rune) (*fontFace, sfnt.GlyphIndex, bool) {
+	for _, face := range f.faces {
+		if idx, ok := face.glyph(r); ok {
+			return face, idx, true
+		}
+	}
+
+	return f.faces[0], 0, false
+}
+
+// tabWidth is how wide a tab is: tab size amount of spaces.
+func (f *font) tabWidth() float64 {
+	face, space, ok := f.faceFor(' ')
+	if !ok {
+		return 0
+	}
+
+	return face.glyphAdvance(space) * float64(f.tabSize)
+}
+
+// textRun is a piece of text shaped with a single face, or a tab.
+type textRun struct{
+	face *fontFace
+	text string
+	start int // of text, in what the run was split from
+	glyphs []runGlyph
+	width float64
+	tab bool
+}
+
+// runGlyph is a glyph and where it goes from the start of its run.
+type runGlyph struct{
+	idx sfnt.GlyphIndex
+	x, y float64
+	cluster int // byte in the run's text the glyph was made from
+}
+
+// runs splits text into runs of one direction, face and script, shapes
+// them and puts them in visual order. runes no face has and the glyphs
+// they'd get are left out.
+func (f *font) runs(text string) []textRun {
+	var runs []textRun
+	bruns, _ := bidiRuns(text)
+	for _, br := range bruns {
+		seg := f.segment(text[br.start:br.end], br.rtl)
+		for i := range seg {
+			seg[i].start += br.start
+		}
+		if br.rtl {
+			for a, b := 0, len(seg) - 1; a < b; a, b = a + 1, b - 1 {
+				seg[a], seg[b] = seg[b], seg[a]
+			}
+		}
+		runs = append(runs, seg...)
+	}
+
+	return runs
+}
+
+// segment splits text of one direction into runs of one face and script.
+func (f *font) segment(text string, rtl bool) []textRun {
+	var runs []textRun
+	var cur *textRun
+	var script language.Script
+	start := 0
+	end := func(i int) {
+		if cur != nil {
+			cur.text = text[start:i]
+			cur.start = start
+			f.shapeRun(cur, script, rtl)
+			runs = append(runs, *cur)
+			cur = nil
+		}
+		start = i
+	}
+
+	for i, r := range text {
+		if r == '\t' {
+			end(i)
+			runs = append(runs, textRun{text: "\t", start: i, width: f.tabWidth(), tab: true})
+			start = i + 1
+			continue
+		}
+
+		face, _, _ := f.faceFor(r)
+		sc, known := textScript(r)
+		if cur != nil && (cur.face != face || known && script != language.Common && sc != script) {
+			end(i)
+		}
+		if cur == nil {
+			cur = &textRun{face: face}
+			start = i
+			script = language.Common
+		}
+		if known && script == language.Common {
+			script = sc
+		}
+	}
+	end(len(text))
+
+	return runs
+}
+
+func (f *font) shapeRun(run *textRun, script language.Script, rtl bool) {
+	for _, g := range run.face.shape(run.text, script, rtl) {
+		if g.idx == 0 {
+			continue
+		}
+		run.glyphs = append(run.glyphs, runGlyph{g.idx, run.width + g.x, g.y, g.cluster})
+		run.width += g.advance
+	}
+}
+
+func (f *font) width(text string) float64 {
+	var w float64
+	for _, run := range f.runs(text) {
+		w += run.width
+	}
+
+	return w
+}
+
+// textTarget is what text gets drawn on.
+type textTarget interface{
+	drawRect(x, y, w, h float64, col color.RGBA)
+	// drawGlyph fills col through a rendered glyph with its top left at a point
+	drawGlyph(g cachedGlyph, at image.Point, col color.RGBA)
+}
+
+// textSpan is a piece of a line drawn in one color.
+type textSpan struct{
+	text string
+	col color.RGBA
+}
+
+// draw draws spans as one line with the top of it at y and returns where it
+// ends. the line is laid out as a whole, so its bidi runs can go across
+// spans, and every glyph takes the color of the span it came from.
+func (f *font) draw(dst textTarget, spans []textSpan, x, y float64) float64 {
+	baseline := math.Round(y + f.ascent())
+
+	text := spans[0].text
+	// where each span starts in text
+	starts := []int{0}
+	for _, s := range spans[1:] {
+		starts = append(starts, len(text))
+		text += s.text
+	}
+	colorAt := func(i int) color.RGBA {
+		return spans[sort.SearchInts(starts, i + 1) - 1].col
+	}
+
+	// decorations go across every run in its color, as the main face says
+	main := f.faces[0]
+	decorate := func(x, w float64, col color.RGBA) {
+		if main.opts.underline {
+			dst.drawRect(x, baseline + main.underline, w, main.lineThickness, col)
+		}
+		if main.opts.strikethrough {
+			dst.drawRect(x, baseline + main.strikethrough, w, main.lineThickness, col)
+		}
+	}
+
+	tx := x
+	for _, run := range f.runs(text) {
+		if run.tab {
+			decorate(tx, run.width, colorAt(run.start))
+			tx += run.width
+			continue
+		}
+
+		face := run.face
+		phases := face.phases()
+		for _, g := range run.glyphs {
+			gx := tx + g.x
+			px := math.Floor(gx)
+			phase := int(math.Round((gx - px) * float64(phases)))
+			if phase == phases {
+				px, phase = px + 1, 0
+			}
+
+			cg := face.rendered(g.idx, phase)
+			if cg.page == nil {
+				continue
+			}
+			at := image.Pt(int(px), int(math.Round(baseline - g.y))).Add(cg.off)
+			dst.drawGlyph(cg, at, colorAt(run.start + g.cluster))
+		}
+		decorate(tx, run.width, colorAt(run.start))
+		tx += run.width
+	}
+
+	return tx
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// glyphTarget keeps the colors glyphs are drawn with, left to right.
+type glyphTarget struct{
+	cols []color.RGBA
+}
+
+func (g *glyphTarget) drawRect(x, y, w, h float64, col color.RGBA) {
+}
+
+func (g *glyphTarget) drawGlyph(cg cachedGlyph, at image.Point, col color.RGBA) {
+	g.cols = append(g.cols, col)
+}
+
+func TestDrawSpanColors(t *testing.T) {
+	file, err := loadFontFile("data/fonts/JetBrainsMono-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFont(newFontFace(file, 14, defaultFontOptions))
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	var dst glyphTarget
+	f.draw(&dst, []textSpan{{"ab", red}, {"cd", blue}, {"e", red}}, 0, 0)
+	want := []color.RGBA{red, red, blue, blue, red}
+	if len(dst.cols) != len(want) {
+		t.Fatalf("drew %d glyphs, want %d", len(dst.cols), len(want))
+	}
+	for i, col := range want {
+		if dst.cols[i] != col {
+			t.Errorf("glyph %d is %v, want %v", i, dst.cols[i], col)
+		}
+	}
+}
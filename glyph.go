@@ -0,0 +1,147 @@
+package main
+
+import (
+	"image"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// size of an atlas page, glyphs bigger than this get a page to themselves
+const glyphPageSize = 512
+
+// how many pages the atlas fills before it starts over, 4MB of glyphs
+const glyphMaxPages = 16
+
+// glyphAtlas is where rasterized glyphs are kept for drawing them again.
+// glyphs of every face are packed into its pages a row (shelf) at a time.
+// the software renderers draw straight from the pages and the gl one keeps
+// a texture of each, uploading what was added since it last looked. once
+// the pages are used up it's emptied and glyphs get rasterized again as
+// they're drawn, so it stays bounded no matter how many fonts, sizes and
+// scripts come by.
+type glyphAtlas struct{
+	pages []*glyphPage
+	x, y, rowHeight int
+	// gen changes whenever the atlas is emptied, glyphs cached before
+	// that aren't in it anymore
+	gen int
+}
+
+// glyphPage is a page of the atlas.
+type glyphPage struct{
+	img *image.Alpha
+	// dirty covers what was added since the page was last uploaded
+	dirty image.Rectangle
+}
+
+var fontAtlas glyphAtlas
+
+// newPage makes room for another page, emptying the atlas if it's full.
+func (a *glyphAtlas) newPage(w, h int) *glyphPage {
+	if len(a.pages) >= glyphMaxPages {
+		a.pages = nil
+		a.gen++
+	}
+	page := &glyphPage{img: image.NewAlpha(image.Rect(0, 0, w, h))}
+	a.pages = append(a.pages, page)
+
+	return page
+}
+
+// add copies a mask into the atlas and returns the page and where in it.
+func (a *glyphAtlas) add(mask *image.Alpha) (*glyphPage, image.Rectangle) {
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	if w > glyphPageSize || h > glyphPageSize {
+		page := a.newPage(w, h)
+		copy(page.img.Pix, mask.Pix)
+		page.dirty = page.img.Rect
+		// the shelf goes on in the last page, which isn't this one
+		a.x, a.y = glyphPageSize, glyphPageSize
+		return page, page.img.Rect
+	}
+
+	if len(a.pages) == 0 || a.x + w > glyphPageSize {
+		// next row
+		a.x = 0
+		a.y += a.rowHeight
+		a.rowHeight = 0
+	}
+	if len(a.pages) == 0 || a.y + h > glyphPageSize {
+		a.newPage(glyphPageSize, glyphPageSize)
+		a.x, a.y, a.rowHeight = 0, 0, 0
+	}
+
+	page := a.pages[len(a.pages) - 1]
+	r := image.Rect(a.x, a.y, a.x + w, a.y + h)
+	for row := 0; row < h; row++ {
+		copy(page.img.Pix[page.img.PixOffset(r.Min.X, r.Min.Y + row):], mask.Pix[row * mask.Stride:row * mask.Stride + w])
+	}
+	page.dirty = page.dirty.Union(r)
+
+	a.x += w
+	if h > a.rowHeight {
+		a.rowHeight = h
+	}
+
+	return page, r
+}
+
+type glyphKey struct{
+	idx sfnt.GlyphIndex
+	phase int
+}
+
+// cachedGlyph is a rasterized glyph. page is nil for glyphs with nothing
+// to draw, like spaces.
+type cachedGlyph struct{
+	page *glyphPage
+	rect image.Rectangle
+	off image.Point
+}
+
+// glyphCache holds what a face already measured and rasterized. a face is
+// a file at one size with one set of options, so it's dropped on resize.
+type glyphCache struct{
+	advances map[sfnt.GlyphIndex]float64
+	shaped map[shapeKey][]shapedGlyph
+	glyphs map[glyphKey]cachedGlyph
+	gen int // of the atlas the glyphs are in
+}
+
+func newGlyphCache() *glyphCache {
+	return &glyphCache{
+		advances: map[sfnt.GlyphIndex]float64{},
+		shaped: map[shapeKey][]shapedGlyph{},
+		glyphs: map[glyphKey]cachedGlyph{},
+		gen: fontAtlas.gen,
+	}
+}
+
+// checkAtlas drops the glyphs if the atlas has been emptied since they
+// were put in it.
+func (c *glyphCache) checkAtlas() {
+	if c.gen != fontAtlas.gen {
+		c.glyphs = map[glyphKey]cachedGlyph{}
+		c.gen = fontAtlas.gen
+	}
+}
+
+// rendered returns a glyph drawn at a phase out of the face's phases.
+func (f *fontFace) rendered(idx sfnt.GlyphIndex, phase int) cachedGlyph {
+	key := glyphKey{idx, phase}
+	f.cache.checkAtlas()
+	if g, ok := f.cache.glyphs[key]; ok {
+		return g
+	}
+
+	var g cachedGlyph
+	mask, off := f.rasterize(idx, float64(phase) / float64(f.phases()))
+	if mask != nil {
+		g.page, g.rect = fontAtlas.add(mask)
+		g.off = off
+		f.cache.checkAtlas()
+	}
+	f.cache.glyphs[key] = g
+
+	return g
+}
@@ -1,21 +1,18 @@
 module feza
 
-go 1.17
+go 1.18
 
 require (
 	github.com/arnodel/golua v0.0.0-20220703095808-4f77264a3871
+	github.com/creack/pty v1.1.21
+	github.com/dlclark/regexp2 v1.11.5
 	github.com/fsnotify/fsnotify v1.5.4
-	github.com/tfriedel6/canvas v0.12.1
+	github.com/go-gl/gl v0.0.0-20181026044259-55b76b7df9d2
+	github.com/go-text/typesetting v0.2.1
 	github.com/veandco/go-sdl2 v0.4.0
-	golang.org/x/exp v0.0.0-20181106170214-d68db9428509
+	golang.org/x/image v0.18.0
+	golang.org/x/sys v0.0.0-20220412211240-33da011f77ad
+	golang.org/x/text v0.16.0
 )
 
-require (
-	github.com/BurntSushi/xgb v0.0.0-20210121224620-deaf085860bc // indirect
-	github.com/arnodel/strftime v0.1.6 // indirect
-	github.com/go-gl/gl v0.0.0-20181026044259-55b76b7df9d2 // indirect
-	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
-	golang.org/x/image v0.0.0-20200119044424-58c23975cae1 // indirect
-	golang.org/x/mobile v0.0.0-20181026062114-a27dd33d354d // indirect
-	golang.org/x/sys v0.0.0-20220412211240-33da011f77ad // indirect
-)
+require github.com/arnodel/strftime v0.1.6 // indirect
@@ -25,6 +25,7 @@ func initLua() {
 	fmt.Println(err)
 	if b, _ := fn.TryBool(); b {
 		// restart
+		killProcesses()
 		goto init
 	}
 }
@@ -2,26 +2,54 @@ package main
 
 import (
 	"log"
+	"os"
 
-	"github.com/tfriedel6/canvas"
-	"github.com/tfriedel6/canvas/sdlcanvas"
 	rt "github.com/arnodel/golua/runtime"
 )
 
 var r *rt.Runtime
-var wnd *sdlcanvas.Window
-var cv *canvas.Canvas
+
+// args is os.Args without feza's own launch options
+var args []string
+// use the login shell's environment for processes (--login-env)
+var useLoginEnv bool
+// draw on the cpu even if opengl works (--software)
+var software bool
+
+// parseLaunchOptions picks out the options meant for feza itself,
+// everything else goes to lua.
+func parseLaunchOptions(osArgs []string) []string {
+	var rest []string
+	for i, arg := range osArgs {
+		switch {
+			case i == 0: rest = append(rest, arg)
+			case arg == "--login-env": useLoginEnv = true
+			case arg == "--software": software = true
+			default: rest = append(rest, arg)
+		}
+	}
+
+	return rest
+}
 
 func main() {
-	var err error
-	w, h := 1280, 720
-	wnd, cv, err = sdlcanvas.CreateWindow(w, h, "")
+	args = parseLaunchOptions(os.Args)
+	if useLoginEnv {
+		// get it while the window comes up instead of on the first process
+		go getLoginEnv()
+	}
+
+	next, cleanup, err := newRenderer(1280, 720)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	defer wnd.Destroy()
+	defer cleanup()
+	// only draw what changed between frames
+	rend = newRenCache(next)
+	setupWakeEvents()
 
 	initLua()
+	killProcesses()
 }
 
@@ -0,0 +1,92 @@
+//go:build headless
+// +build headless
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// boots the editor from data/ like init.lua does, draws a frame and checks
+// what ended up in the image. run with go test -tags headless.
+func TestHeadlessFrame(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keep the user's config and plugins out of it
+	t.Setenv("LITE_USERDIR", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	head := newHeadlessRenderer(640, 480)
+	rend = newRenCache(head)
+	setupWakeEvents()
+	defer killProcesses()
+
+	args = []string{filepath.Join(wd, "feza")}
+	r = rt.New(os.Stdout)
+	lib.LoadAll(r)
+	setupAPI()
+	// the data dir is found from the executable, which here is the test
+	r.SetEnv(r.GlobalEnv(), "EXEFILE", rt.StringValue(args[0]))
+
+	script := filepath.Join(t.TempDir(), "frame.lua")
+	err = os.WriteFile(script, []byte(`
+		-- os.date needs its arguments, init.lua fills them in the same way
+		local date = os.date
+		function os.date(format, time)
+			return date(format or '%a %b %d %X %Y', time or os.time())
+		end
+		dofile(EXEFILE:match('^(.*)/[^/]+$') .. '/data/core/start.lua')
+		local core = require 'core'
+		local style = require 'core.style'
+		core.init()
+		core.redraw = true
+		core.step()
+		return style.background
+	`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := doFile(r, script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bg, ok := v.TryTable()
+	if !ok {
+		t.Fatalf("style.background is %v", v)
+	}
+	var want [3]uint8
+	for i := range want {
+		n, _ := rt.ToInt(bg.Get(rt.IntValue(int64(i + 1))))
+		want[i] = uint8(n)
+	}
+
+	img := head.image()
+	var background, other int
+	for i := 0; i < len(img.Pix); i += 4 {
+		px := img.Pix[i:i + 4]
+		switch {
+			case px[3] == 0:
+				t.Fatalf("pixel %d wasn't drawn", i / 4)
+			case px[0] == want[0] && px[1] == want[1] && px[2] == want[2]:
+				background++
+			default:
+				other++
+		}
+	}
+	// mostly the empty editor, with a status bar, tabs and text on it
+	total := len(img.Pix) / 4
+	if background < total / 2 {
+		t.Errorf("only %d of %d pixels are the background %v", background, total, want)
+	}
+	if other == 0 {
+		t.Errorf("nothing but background was drawn")
+	}
+}
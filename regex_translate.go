@@ -0,0 +1,800 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// runeRange is an inclusive range of runes in a character class.
+type runeRange struct{
+	lo, hi rune
+}
+
+// classes for the escapes that mean something else to regexp2: pcre is
+// used with UTF but without UCP, so \d, \s and \w only match ascii, while
+// .NET takes them as unicode classes. ranges are sorted and don't overlap.
+var (
+	asciiDigit = []runeRange{{'0', '9'}}
+	asciiSpace = []runeRange{{'\t', '\r'}, {' ', ' '}}
+	asciiWord = []runeRange{{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}}
+	horizSpace = []runeRange{{'\t', '\t'}, {' ', ' '}, {0xa0, 0xa0}, {0x1680, 0x1680}, {0x180e, 0x180e}, {0x2000, 0x200a}, {0x202f, 0x202f}, {0x205f, 0x205f}, {0x3000, 0x3000}}
+	vertSpace = []runeRange{{'\n', '\r'}, {0x85, 0x85}, {0x2028, 0x2029}}
+)
+
+// posix classes, which regexp2 doesn't know about
+var posixClasses = map[string][]runeRange{
+	"alnum": {{'0', '9'}, {'A', 'Z'}, {'a', 'z'}},
+	"alpha": {{'A', 'Z'}, {'a', 'z'}},
+	"ascii": {{0, 0x7f}},
+	"blank": {{'\t', '\t'}, {' ', ' '}},
+	"cntrl": {{0, 0x1f}, {0x7f, 0x7f}},
+	"digit": asciiDigit,
+	"graph": {{0x21, 0x7e}},
+	"lower": {{'a', 'z'}},
+	"print": {{0x20, 0x7e}},
+	"punct": {{'!', '/'}, {':', '@'}, {'[', '`'}, {'{', '~'}},
+	"space": asciiSpace,
+	"upper": {{'A', 'Z'}},
+	"word": asciiWord,
+	"xdigit": {{'0', '9'}, {'A', 'F'}, {'a', 'f'}},
+}
+
+// classEscapes are the escapes that stand for a class, lowercase for the
+// class and uppercase for everything else
+var classEscapes = map[rune][]runeRange{
+	'd': asciiDigit,
+	's': asciiSpace,
+	'w': asciiWord,
+	'h': horizSpace,
+	'v': vertSpace,
+}
+
+// classContents writes ranges as the inside of a class, or everything not
+// in them if negate is set, so negated classes can go in other classes.
+func classContents(ranges []runeRange, negate bool) string {
+	if negate {
+		var inverse []runeRange
+		next := rune(0)
+		for _, r := range ranges {
+			if r.lo > next {
+				inverse = append(inverse, runeRange{next, r.lo - 1})
+			}
+			next = r.hi + 1
+		}
+		if next <= unicode.MaxRune {
+			inverse = append(inverse, runeRange{next, unicode.MaxRune})
+		}
+		ranges = inverse
+	}
+
+	var sb strings.Builder
+	for _, r := range ranges {
+		fmt.Fprintf(&sb, `\x{%x}`, r.lo)
+		if r.hi != r.lo {
+			fmt.Fprintf(&sb, `-\x{%x}`, r.hi)
+		}
+	}
+
+	return sb.String()
+}
+
+// classEscape returns the class contents for a class escape like \d.
+func classEscape(c rune) (string, bool) {
+	ranges, ok := classEscapes[unicode.ToLower(c)]
+	if !ok {
+		return "", false
+	}
+
+	return classContents(ranges, unicode.IsUpper(c)), true
+}
+
+// ascii word boundaries for \b and \B, .NET's are unicode aware
+const (
+	asciiBoundary = `(?:(?<=[0-9A-Z_a-z])(?![0-9A-Z_a-z])|(?<![0-9A-Z_a-z])(?=[0-9A-Z_a-z]))`
+	asciiNonBoundary = `(?:(?<=[0-9A-Z_a-z])(?=[0-9A-Z_a-z])|(?<![0-9A-Z_a-z])(?![0-9A-Z_a-z]))`
+)
+
+// pcreOptions are the things that change how a pattern is translated.
+type pcreOptions struct{
+	extended bool // x flag, whitespace and # comments are ignored
+	multiline bool
+	notbol bool // ^ can't match at the start of the subject
+	noteol bool // $ can't match at the end of the subject
+}
+
+// pcrePattern is a pattern translated for regexp2.
+type pcrePattern struct{
+	expr string
+	groups int // amount of capture groups
+	names map[string]int // capture group numbers by name
+}
+
+// pcreGroup is an open group, with the flags to go back to when it closes.
+type pcreGroup struct{
+	start int
+	extended bool
+	multiline bool
+	nocapture bool
+}
+
+// pcreTranslator rewrites the parts of PCRE syntax that regexp2 doesn't
+// support (or reads differently) into equivalents it does. regexp2 follows
+// .NET, which is already very close to PCRE: lookaround, backreferences,
+// atomic groups and named groups all work as is.
+//
+// What has no equivalent is refused with an error: recursion and
+// subroutine calls ((?R), (?1), (?&name), \g<name>), branch reset groups
+// (?|...), \K, callouts and backtracking verbs other than (*UTF)/(*UCP).
+//
+// .NET numbers named groups after all unnamed ones while PCRE numbers them
+// in order, so every capture group gets an explicit number here.
+type pcreTranslator struct{
+	pat []rune
+	pos int
+	out []rune
+	opts pcreOptions
+	nocapture bool // n flag, plain parentheses don't capture
+	groups []pcreGroup
+	atom int // where the last quantifiable thing starts in out, -1 if none
+	captures int
+	names map[string]int
+}
+
+func pcreToRegexp2(pattern []rune, opts pcreOptions) (*pcrePattern, error) {
+	tr := &pcreTranslator{
+		pat: pattern,
+		opts: opts,
+		atom: -1,
+		names: make(map[string]int),
+	}
+	if err := tr.translate(); err != nil {
+		return nil, err
+	}
+
+	return &pcrePattern{
+		expr: string(tr.out),
+		groups: tr.captures,
+		names: tr.names,
+	}, nil
+}
+
+func (tr *pcreTranslator) more() bool {
+	return tr.pos < len(tr.pat)
+}
+
+func (tr *pcreTranslator) peek(n int) rune {
+	if tr.pos + n >= len(tr.pat) {
+		return 0
+	}
+	return tr.pat[tr.pos + n]
+}
+
+func (tr *pcreTranslator) hasPrefix(s string) bool {
+	return strings.HasPrefix(string(tr.pat[tr.pos:]), s)
+}
+
+func (tr *pcreTranslator) emit(s string) {
+	tr.out = append(tr.out, []rune(s)...)
+}
+
+func (tr *pcreTranslator) emitAtom(s string) {
+	tr.atom = len(tr.out)
+	tr.emit(s)
+}
+
+// until returns everything up to and including the next end rune,
+// moving past it.
+func (tr *pcreTranslator) until(end rune) (string, error) {
+	start := tr.pos
+	for tr.more() {
+		c := tr.pat[tr.pos]
+		tr.pos++
+		if c == end {
+			return string(tr.pat[start:tr.pos]), nil
+		}
+	}
+
+	return "", fmt.Errorf("missing %c at offset %d", end, start)
+}
+
+func (tr *pcreTranslator) translate() error {
+	for tr.more() {
+		c := tr.pat[tr.pos]
+		switch c {
+			case '\\':
+				if err := tr.escape(); err != nil {
+					return err
+				}
+			case '[':
+				start := len(tr.out)
+				if err := tr.class(); err != nil {
+					return err
+				}
+				tr.atom = start
+			case '(':
+				if err := tr.group(); err != nil {
+					return err
+				}
+			case ')':
+				tr.pos++
+				if len(tr.groups) == 0 {
+					return fmt.Errorf("unmatched closing parenthesis at offset %d", tr.pos - 1)
+				}
+				g := tr.groups[len(tr.groups) - 1]
+				tr.groups = tr.groups[:len(tr.groups) - 1]
+				tr.opts.extended = g.extended
+				tr.opts.multiline = g.multiline
+				tr.nocapture = g.nocapture
+				tr.atom = g.start
+				tr.emit(")")
+			case '*', '+', '?':
+				tr.pos++
+				tr.emit(string(c))
+				tr.quantified()
+			case '{':
+				if q := tr.braceQuantifier(); q != "" {
+					tr.pos += len([]rune(q))
+					tr.emit(q)
+					tr.quantified()
+				} else {
+					tr.pos++
+					tr.emitAtom(`\{`)
+				}
+			case '^':
+				tr.pos++
+				tr.atom = -1
+				if tr.opts.notbol {
+					tr.emit(`(?!\A)^`)
+				} else {
+					tr.emit("^")
+				}
+			case '$':
+				tr.pos++
+				tr.atom = -1
+				switch {
+					case !tr.opts.noteol: tr.emit("$")
+					case tr.opts.multiline: tr.emit(`(?!\z)$`)
+					default: tr.emit(`(?!)`)
+				}
+			case '|':
+				tr.pos++
+				tr.atom = -1
+				tr.emit("|")
+			case ' ', '\t', '\n', '\r', '\v', '\f':
+				tr.pos++
+				if !tr.opts.extended {
+					tr.emitAtom(string(c))
+				}
+			case '#':
+				if tr.opts.extended {
+					for tr.more() && tr.pat[tr.pos] != '\n' {
+						tr.pos++
+					}
+				} else {
+					tr.pos++
+					tr.emitAtom("#")
+				}
+			default:
+				tr.pos++
+				tr.emitAtom(string(c))
+		}
+	}
+
+	if len(tr.groups) != 0 {
+		return fmt.Errorf("missing closing parenthesis")
+	}
+
+	return nil
+}
+
+// quantified handles what can follow a quantifier: ? makes it lazy, which
+// regexp2 understands, and + makes it possessive, which it doesn't, so
+// that becomes an atomic group around the quantified atom.
+func (tr *pcreTranslator) quantified() {
+	switch tr.peek(0) {
+		case '?':
+			tr.pos++
+			tr.emit("?")
+		case '+':
+			tr.pos++
+			if tr.atom == -1 {
+				return
+			}
+			quantified := append([]rune("(?>"), tr.out[tr.atom:]...)
+			tr.out = append(tr.out[:tr.atom], append(quantified, ')')...)
+	}
+	tr.atom = -1
+}
+
+// braceQuantifier returns the {n}, {n,} or {n,m} quantifier at the current
+// position, or "" if the brace is a literal like pcre treats it.
+func (tr *pcreTranslator) braceQuantifier() string {
+	i := tr.pos + 1
+	digits := func() int {
+		n := 0
+		for i < len(tr.pat) && tr.pat[i] >= '0' && tr.pat[i] <= '9' {
+			i++
+			n++
+		}
+		return n
+	}
+
+	if digits() == 0 {
+		return ""
+	}
+	if i < len(tr.pat) && tr.pat[i] == ',' {
+		i++
+		digits()
+	}
+	if i >= len(tr.pat) || tr.pat[i] != '}' {
+		return ""
+	}
+
+	return string(tr.pat[tr.pos:i + 1])
+}
+
+// backref emits a reference to a group by number or name.
+func (tr *pcreTranslator) backref(ref string, at int) error {
+	if n, err := strconv.Atoi(ref); err == nil && n > 0 {
+		tr.emitAtom(`(?:\` + ref + `)`)
+		return nil
+	}
+
+	n, ok := tr.names[ref]
+	if !ok {
+		return fmt.Errorf("reference to non-existent subpattern %q at offset %d", ref, at)
+	}
+	tr.emitAtom(`(?:\` + strconv.Itoa(n) + `)`)
+
+	return nil
+}
+
+func (tr *pcreTranslator) escape() error {
+	start := tr.pos
+	tr.pos++
+	if !tr.more() {
+		return fmt.Errorf("\\ at end of pattern")
+	}
+	c := tr.pat[tr.pos]
+	tr.pos++
+
+	switch c {
+		case 'Q':
+			// literal text up to \E
+			for tr.more() && !tr.hasPrefix(`\E`) {
+				tr.emitAtom(escapeRegexRune(tr.pat[tr.pos]))
+				tr.pos++
+			}
+			if tr.more() {
+				tr.pos += 2
+			}
+		case 'E':
+			// stray \E is ignored by pcre
+		case 'd', 'D', 's', 'S', 'w', 'W', 'h', 'H', 'v', 'V':
+			contents, _ := classEscape(c)
+			tr.emitAtom("[" + contents + "]")
+		case 'N':
+			tr.emitAtom(`[^\n]`)
+		case 'b':
+			tr.emitAtom(asciiBoundary)
+		case 'B':
+			tr.emitAtom(asciiNonBoundary)
+		case 'o':
+			r, err := tr.octal(start)
+			if err != nil {
+				return err
+			}
+			tr.emitAtom(r)
+		case 'R':
+			tr.emitAtom(`(?:\r\n|[\n\v\f\r\x85\u2028\u2029])`)
+		case 'K':
+			return fmt.Errorf("\\K is not supported at offset %d", start)
+		case 'g':
+			// \gN, \g{N} and \g{name} backreferences
+			var ref string
+			if tr.peek(0) == '{' {
+				tr.pos++
+				r, err := tr.until('}')
+				if err != nil {
+					return err
+				}
+				ref = strings.TrimSuffix(r, "}")
+			} else {
+				s := tr.pos
+				if tr.peek(0) == '-' {
+					tr.pos++
+				}
+				for tr.more() && tr.pat[tr.pos] >= '0' && tr.pat[tr.pos] <= '9' {
+					tr.pos++
+				}
+				ref = string(tr.pat[s:tr.pos])
+			}
+			if strings.HasPrefix(ref, "-") {
+				// relative to the last group opened so far
+				n, err := strconv.Atoi(ref[1:])
+				if err != nil || n == 0 || n > tr.captures {
+					return fmt.Errorf("reference to non-existent subpattern at offset %d", start)
+				}
+				ref = strconv.Itoa(tr.captures + 1 - n)
+			}
+			if ref == "" || strings.HasPrefix(ref, "+") {
+				return fmt.Errorf("unsupported \\g reference at offset %d", start)
+			}
+			return tr.backref(ref, start)
+		case 'k':
+			// \k<name>, \k'name' and \k{name}
+			var closer rune
+			switch tr.peek(0) {
+				case '{': closer = '}'
+				case '<': closer = '>'
+				case '\'': closer = '\''
+				default: return fmt.Errorf("\\k is not followed by a group name at offset %d", start)
+			}
+			tr.pos++
+			name, err := tr.until(closer)
+			if err != nil {
+				return err
+			}
+			return tr.backref(strings.TrimSuffix(name, string(closer)), start)
+		case 'x', 'p', 'P', 'c':
+			// the whole escape is the atom, or a quantifier after it
+			// would only get its last character
+			arg := ""
+			switch {
+				case c != 'c' && tr.peek(0) == '{':
+					r, err := tr.until('}')
+					if err != nil {
+						return err
+					}
+					arg = r
+				case c == 'x':
+					// up to two hex digits
+					s := tr.pos
+					for tr.pos < s + 2 && tr.more() && strings.ContainsRune("0123456789abcdefABCDEF", tr.pat[tr.pos]) {
+						tr.pos++
+					}
+					arg = string(tr.pat[s:tr.pos])
+				case tr.more():
+					// a one letter property like \pL, or \cX
+					arg = string(tr.pat[tr.pos])
+					tr.pos++
+				default:
+					return fmt.Errorf("\\%c at end of pattern", c)
+			}
+			tr.emitAtom(`\` + string(c) + arg)
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			return tr.digitEscape(start)
+		default:
+			tr.emitAtom(`\` + string(c))
+	}
+
+	return nil
+}
+
+// digitEscape handles an escape starting with a digit. like pcre, it's a
+// backreference if it's below 10, starts with 8 or 9 or is a group that's
+// been opened already, and otherwise up to three octal digits.
+func (tr *pcreTranslator) digitEscape(at int) error {
+	start := tr.pos - 1
+	for tr.more() && tr.pat[tr.pos] >= '0' && tr.pat[tr.pos] <= '9' {
+		tr.pos++
+	}
+	digits := string(tr.pat[start:tr.pos])
+	if n, _ := strconv.Atoi(digits); digits[0] != '0' && (n < 10 || digits[0] >= '8' || n <= tr.captures) {
+		return tr.backref(digits, at)
+	}
+
+	tr.pos = start
+	for tr.pos < start + 3 && tr.more() && tr.pat[tr.pos] >= '0' && tr.pat[tr.pos] <= '7' {
+		tr.pos++
+	}
+	n, _ := strconv.ParseUint(string(tr.pat[start:tr.pos]), 8, 32)
+	tr.emitAtom(fmt.Sprintf(`\x{%x}`, n))
+
+	return nil
+}
+
+// octal reads the {digits} of a \o escape and returns the rune as an
+// escape regexp2 knows.
+func (tr *pcreTranslator) octal(at int) (string, error) {
+	if tr.peek(0) != '{' {
+		return "", fmt.Errorf("missing opening brace after \\o at offset %d", at)
+	}
+	tr.pos++
+	digits, err := tr.until('}')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(strings.TrimSuffix(digits, "}"), 8, 32)
+	if err != nil || n > unicode.MaxRune {
+		return "", fmt.Errorf("invalid octal escape at offset %d", at)
+	}
+
+	return fmt.Sprintf(`\x{%x}`, n), nil
+}
+
+func (tr *pcreTranslator) class() error {
+	start := tr.pos
+	tr.pos++
+	tr.emit("[")
+	if tr.peek(0) == '^' {
+		tr.pos++
+		tr.emit("^")
+	}
+	if tr.peek(0) == ']' {
+		// ] first in a class is a literal
+		tr.pos++
+		tr.emit(`\]`)
+	}
+
+	for tr.more() {
+		c := tr.pat[tr.pos]
+		switch {
+			case c == ']':
+				tr.pos++
+				tr.emit("]")
+				return nil
+			case c == '\\':
+				tr.pos++
+				if !tr.more() {
+					return fmt.Errorf("\\ at end of pattern")
+				}
+				e := tr.pat[tr.pos]
+				tr.pos++
+				if contents, ok := classEscape(e); ok {
+					tr.emit(contents)
+					continue
+				}
+				switch e {
+					case 'o':
+						r, err := tr.octal(tr.pos - 2)
+						if err != nil {
+							return err
+						}
+						tr.emit(r)
+					case 'Q':
+						for tr.more() && !tr.hasPrefix(`\E`) {
+							tr.emit(escapeRegexRune(tr.pat[tr.pos]))
+							tr.pos++
+						}
+						if tr.more() {
+							tr.pos += 2
+						}
+					case 'E':
+					default: tr.emit(`\` + string(e))
+				}
+			case c == '[' && tr.peek(1) == ':' && tr.posixClassName() != "":
+				name := tr.posixClassName()
+				negate := strings.HasPrefix(name, "^")
+				ranges, ok := posixClasses[strings.TrimPrefix(name, "^")]
+				if !ok {
+					return fmt.Errorf("unknown posix class name %q at offset %d", name, tr.pos)
+				}
+				tr.pos += len("[:" + name + ":]")
+				tr.emit(classContents(ranges, negate))
+			case c == '[':
+				// a literal [ inside a class, regexp2 would take it as
+				// the start of a subtraction
+				tr.pos++
+				tr.emit(`\[`)
+			default:
+				tr.pos++
+				tr.emit(string(c))
+		}
+	}
+
+	return fmt.Errorf("missing terminating ] for character class at offset %d", start)
+}
+
+// posixClassName returns the name of the [:name:] at the current position,
+// with a ^ in front if it's negated, or "" if it's not one and the [ is
+// a literal.
+func (tr *pcreTranslator) posixClassName() string {
+	i := tr.pos + 2
+	if i < len(tr.pat) && tr.pat[i] == '^' {
+		i++
+	}
+	for i < len(tr.pat) && tr.pat[i] >= 'a' && tr.pat[i] <= 'z' {
+		i++
+	}
+	if i == tr.pos + 2 || i + 1 >= len(tr.pat) || tr.pat[i] != ':' || tr.pat[i + 1] != ']' {
+		return ""
+	}
+
+	return string(tr.pat[tr.pos + 2:i])
+}
+
+// open starts a group in the output, remembering the flags to restore.
+func (tr *pcreTranslator) open(s string) {
+	tr.groups = append(tr.groups, pcreGroup{
+		start: len(tr.out),
+		extended: tr.opts.extended,
+		multiline: tr.opts.multiline,
+		nocapture: tr.nocapture,
+	})
+	tr.emit(s)
+}
+
+// capture starts a capture group, named if name isn't empty.
+func (tr *pcreTranslator) capture(name string, at int) error {
+	tr.captures++
+	if name != "" {
+		if _, ok := tr.names[name]; ok {
+			return fmt.Errorf("two named subpatterns have the same name %q at offset %d", name, at)
+		}
+		tr.names[name] = tr.captures
+	}
+	tr.open("(?<" + strconv.Itoa(tr.captures) + ">")
+
+	return nil
+}
+
+func (tr *pcreTranslator) group() error {
+	start := tr.pos
+	switch {
+		case tr.hasPrefix("(?#"):
+			// comment
+			if _, err := tr.until(')'); err != nil {
+				return err
+			}
+			return nil
+		case tr.hasPrefix("(?<="), tr.hasPrefix("(?<!"):
+			tr.pos += 4
+			tr.open(string(tr.pat[start:tr.pos]))
+			return nil
+		case tr.hasPrefix("(?<"), tr.hasPrefix("(?'"), tr.hasPrefix("(?P<"):
+			closer := '>'
+			if tr.peek(2) == '\'' {
+				closer = '\''
+			}
+			tr.pos += 3
+			if tr.peek(-1) == 'P' {
+				tr.pos++
+			}
+			name, err := tr.until(closer)
+			if err != nil {
+				return err
+			}
+			return tr.capture(strings.TrimSuffix(name, string(closer)), start)
+		case tr.hasPrefix("(?P="):
+			tr.pos += 4
+			name, err := tr.until(')')
+			if err != nil {
+				return err
+			}
+			return tr.backref(strings.TrimSuffix(name, ")"), start)
+		case tr.hasPrefix("(?P>"), tr.hasPrefix("(?R"), tr.hasPrefix("(?&"):
+			return fmt.Errorf("recursion is not supported at offset %d", start)
+		case tr.hasPrefix("(?|"):
+			return fmt.Errorf("branch reset groups are not supported at offset %d", start)
+		case tr.hasPrefix("(?("):
+			// conditional, named conditions refer to groups by number here
+			tr.pos += 2
+			if tr.peek(1) == '?' || tr.peek(1) == '*' {
+				// an assertion, which translates like any other group
+				tr.open("(?")
+				return nil
+			}
+			tr.pos++
+			cond, err := tr.until(')')
+			if err != nil {
+				return err
+			}
+			cond = strings.TrimSuffix(cond, ")")
+			if strings.HasPrefix(cond, "R") {
+				return fmt.Errorf("recursion is not supported at offset %d", start)
+			}
+			if len(cond) > 1 && (cond[0] == '<' || cond[0] == '\'') {
+				cond = cond[1:len(cond) - 1]
+			}
+			if _, err := strconv.Atoi(cond); err != nil {
+				n, ok := tr.names[cond]
+				if !ok {
+					return fmt.Errorf("reference to non-existent subpattern %q at offset %d", cond, start)
+				}
+				cond = strconv.Itoa(n)
+			}
+			tr.open("(?(" + cond + ")")
+			return nil
+		case tr.hasPrefix("(*"):
+			// verbs like (*UTF) at the start of the pattern are options
+			// we already have on, anything else we can't do
+			verb, err := tr.until(')')
+			if err != nil {
+				return err
+			}
+			switch verb {
+				case "(*UTF)", "(*UTF8)", "(*UCP)":
+					return nil
+			}
+			return fmt.Errorf("unsupported verb %s at offset %d", verb, start)
+		case tr.hasPrefix("(?"):
+			c := tr.peek(2)
+			if c >= '0' && c <= '9' || c == '+' || c == '-' && tr.peek(3) >= '0' && tr.peek(3) <= '9' {
+				return fmt.Errorf("recursion is not supported at offset %d", start)
+			}
+			if strings.ContainsRune(":=!>", c) {
+				tr.pos += 3
+				tr.open(string(tr.pat[start:tr.pos]))
+				return nil
+			}
+			return tr.flagGroup()
+	}
+
+	tr.pos++
+	if tr.nocapture {
+		tr.open("(?:")
+		return nil
+	}
+
+	return tr.capture("", start)
+}
+
+// flagGroup handles (?flags) and (?flags:...), keeping track of the flags
+// that change how the rest of the pattern is translated.
+func (tr *pcreTranslator) flagGroup() error {
+	start := tr.pos
+	tr.pos += 2
+
+	on := true
+	// the flags regexp2 is told about, true to turn them on
+	set := map[rune]bool{}
+	extended, multiline, nocapture := tr.opts.extended, tr.opts.multiline, tr.nocapture
+	for tr.more() {
+		c := tr.pat[tr.pos]
+		tr.pos++
+		switch c {
+			case '-':
+				on = false
+			case '^':
+				// unset imnsx, letters after it turn flags back on
+				extended, multiline, nocapture = false, false, false
+				for _, f := range "ims" {
+					set[f] = false
+				}
+			case 'i', 's':
+				set[c] = on
+			case 'm':
+				multiline = on
+				set[c] = on
+			case 'x':
+				extended = on
+			case 'n':
+				nocapture = on
+			case ')', ':':
+				var ons, offs string
+				for _, f := range "ims" {
+					if v, ok := set[f]; ok && v {
+						ons += string(f)
+					} else if ok {
+						offs += string(f)
+					}
+				}
+				f := ons
+				if offs != "" {
+					f += "-" + offs
+				}
+				if c == ':' {
+					tr.open("(?" + f + ":")
+				} else if f != "" {
+					tr.emit("(?" + f + ")")
+				}
+				tr.opts.extended, tr.opts.multiline, tr.nocapture = extended, multiline, nocapture
+				return nil
+			default:
+				return fmt.Errorf("unrecognized option %q in group at offset %d", c, start)
+		}
+	}
+
+	return fmt.Errorf("missing ) at offset %d", start)
+}
+
+func escapeRegexRune(c rune) string {
+	if strings.ContainsRune(`\.+*?()|[]{}^$# `, c) {
+		return `\` + string(c)
+	}
+
+	return string(c)
+}
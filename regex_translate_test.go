@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRegexTranslate(t *testing.T) {
+	tests := []struct{
+		pattern, subject string
+		match bool
+	}{
+		// a [ that doesn't start a posix class is a literal
+		{"[[:]", ":", true},
+		{"[[:]", "a", false},
+		{"[[:a]", "a", true},
+		{"[[:alpha:]]+", "abc", true},
+		{"[[:^digit:]]", "1", false},
+		// quantifiers go on the whole escape
+		{`^\x41++$`, "AAA", true},
+		{`^\x{41}++$`, "AAA", true},
+		{`^\pL++$`, "abc", true},
+		{`^\p{L}++$`, "abc", true},
+		{`^\cA++$`, "\x01\x01", true},
+		{`^\101++$`, "AAA", true},
+		{`^\0101$`, "\x081", true},
+		{`^(a)\1++$`, "aaa", true},
+		{`^(a)\12$`, "a\n", true},
+		// letters after ^ turn flags on
+		{`(?^i)a`, "A", true},
+		{`(?i)(?^)a`, "A", false},
+		{`(?i)(?^m:a)`, "A", false},
+		{`(?^x)a b`, "ab", true},
+		{`(?x)(?^)a b`, "ab", false},
+		{`(?^ix)a b`, "AB", true},
+		{`(?i-s:a.)`, "A\n", false},
+	}
+	for _, test := range tests {
+		re, err := newRegex(test.pattern, "")
+		if err != nil {
+			t.Errorf("%q: %v", test.pattern, err)
+			continue
+		}
+		if ok, _ := re.re.MatchString(test.subject); ok != test.match {
+			t.Errorf("%q matching %q: got %v, translated to %q", test.pattern, test.subject, ok, re.expr)
+		}
+	}
+}
+
+// every short pattern made of class syntax translates or fails cleanly
+func TestRegexTranslateClasses(t *testing.T) {
+	chars := []rune("[]:^a\\-")
+	var gen func(prefix []rune, n int)
+	gen = func(prefix []rune, n int) {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%q: %v", string(prefix), r)
+				}
+			}()
+			pcreToRegexp2(prefix, pcreOptions{})
+		}()
+		if n == 0 {
+			return
+		}
+		for _, c := range chars {
+			gen(append(prefix[:len(prefix):len(prefix)], c), n - 1)
+		}
+	}
+	gen(nil, 6)
+}
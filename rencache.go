@@ -0,0 +1,331 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"strings"
+	"unsafe"
+)
+
+// renCache sits between the renderer module and a renderer. it records
+// what a frame draws, hashes it into a grid of cells, and on end_frame
+// only redraws the cells that changed since the last frame, like the
+// rencache of lite xl.
+type renCache struct{
+	next renderer
+	cmds []drawCmd
+	clip image.Rectangle
+
+	w, h int
+	cols, rows int
+	cells, prevCells []uint32
+	// invalid is set when the previous frame can't be trusted, like
+	// after a resize, so everything gets redrawn
+	invalid bool
+
+	// debug fills redrawn regions with a random color
+	debug bool
+}
+
+// size of a cell of the grid, in pixels
+const renCellSize = 96
+
+type drawCmdKind int
+
+const (
+	drawCmdRect drawCmdKind = iota
+	drawCmdText
+)
+
+type drawCmd struct{
+	kind drawCmdKind
+	// rect is what the command covers, clipped
+	rect image.Rectangle
+	clip image.Rectangle
+	x, y, w, h float64
+	col color.RGBA
+	font *font
+	// spans of a text command, which is a whole line when draw_text calls
+	// carry on from where the last one ended
+	spans []textSpan
+	// end is where the next draw_text call carries on from, the widths of
+	// the calls added up. w is the width of the joined text, which shaping
+	// across the calls can make different.
+	end float64
+}
+
+// partialRenderer is a renderer that keeps what it drew between frames,
+// so only some of it has to be drawn again.
+type partialRenderer interface{
+	// endFrameRects ends a frame that only drew in rects.
+	endFrameRects(rects []image.Rectangle)
+}
+
+func newRenCache(next renderer) *renCache {
+	return &renCache{next: next, invalid: true}
+}
+
+// invalidate makes the next frame draw everything again.
+func (c *renCache) invalidate() {
+	c.invalid = true
+}
+
+// invalidateRenCache invalidates the render cache, if there is one.
+func invalidateRenCache() {
+	if cache, ok := rend.(*renCache); ok {
+		cache.invalidate()
+	}
+}
+
+func (c *renCache) beginFrame() {
+	w, h := c.next.size()
+	if w != c.w || h != c.h {
+		c.w, c.h = w, h
+		c.cols = (w + renCellSize - 1) / renCellSize
+		c.rows = (h + renCellSize - 1) / renCellSize
+		c.cells = make([]uint32, c.cols * c.rows)
+		c.prevCells = make([]uint32, c.cols * c.rows)
+		c.invalid = true
+	}
+
+	c.cmds = c.cmds[:0]
+	c.clip = c.screen()
+}
+
+func (c *renCache) screen() image.Rectangle {
+	return image.Rect(0, 0, c.w, c.h)
+}
+
+func (c *renCache) setClip(rect *[4]float64) {
+	if rect == nil {
+		c.clip = c.screen()
+		return
+	}
+
+	c.clip = floatRect(rect[0], rect[1], rect[2], rect[3]).Intersect(c.screen())
+}
+
+// floatRect is the smallest pixel rect that covers a float one.
+func floatRect(x, y, w, h float64) image.Rectangle {
+	return image.Rect(int(math.Floor(x)), int(math.Floor(y)), int(math.Ceil(x + w)), int(math.Ceil(y + h)))
+}
+
+func (c *renCache) push(cmd drawCmd) {
+	cmd.clip = c.clip
+	cmd.rect = cmd.rect.Intersect(c.clip)
+	if cmd.rect.Empty() {
+		return
+	}
+
+	c.cmds = append(c.cmds, cmd)
+}
+
+func (c *renCache) drawRect(x, y, w, h float64, col color.RGBA) {
+	if col.A == 0 {
+		return
+	}
+
+	c.push(drawCmd{
+		kind: drawCmdRect,
+		rect: floatRect(x, y, w, h),
+		x: x, y: y, w: w, h: h,
+		col: col,
+	})
+}
+
+// drawText records text, joined to the text command before it if it goes
+// on from where that one ended. docview draws a line a token at a time, this
+// way the line is still laid out as one and bidi can reorder across tokens.
+func (c *renCache) drawText(f *font, spans []textSpan, x, y float64) float64 {
+	var w float64
+	for _, s := range spans {
+		w += f.width(s.text)
+	}
+	// glyphs can reach a bit past their advances, like italics do, and
+	// above and below the line, like stacked diacritics do
+	pad := math.Ceil(f.height() / 4)
+	rect := floatRect(x - pad, y - pad, w + pad * 2, f.height() + pad * 2)
+
+	if n := len(c.cmds); n > 0 {
+		last := &c.cmds[n - 1]
+		if last.kind == drawCmdText && last.font == f && last.clip == c.clip && last.y == y && last.end == x {
+			last.spans = append(last.spans, spans...)
+			var text strings.Builder
+			for _, s := range last.spans {
+				text.WriteString(s.text)
+			}
+			last.w = f.width(text.String())
+			last.end = x + w
+			rect = floatRect(last.x - pad, y - pad, math.Max(last.w, last.end - last.x) + pad * 2, f.height() + pad * 2)
+			last.rect = rect.Intersect(c.clip)
+			return last.end
+		}
+	}
+
+	visible := false
+	for _, s := range spans {
+		visible = visible || s.col.A != 0 && s.text != ""
+	}
+	if visible {
+		c.push(drawCmd{
+			kind: drawCmdText,
+			rect: rect,
+			x: x, y: y, w: w,
+			font: f,
+			spans: spans,
+			end: x + w,
+		})
+	}
+
+	return x + w
+}
+
+func (c *renCache) size() (int, int) {
+	return c.next.size()
+}
+
+func (c *renCache) endFrame() {
+	for i := range c.cells {
+		c.cells[i] = fnvOffset
+	}
+	for _, cmd := range c.cmds {
+		c.hashCells(cmd.rect, cmd.hash())
+	}
+
+	var rects []image.Rectangle
+	for y := 0; y < c.rows; y++ {
+		for x := 0; x < c.cols; x++ {
+			i := x + y * c.cols
+			if !c.invalid && c.cells[i] == c.prevCells[i] {
+				continue
+			}
+			r := image.Rect(x * renCellSize, y * renCellSize, (x + 1) * renCellSize, (y + 1) * renCellSize).Intersect(c.screen())
+			rects = mergeRect(rects, r)
+		}
+	}
+	c.cells, c.prevCells = c.prevCells, c.cells
+	c.invalid = false
+
+	if len(rects) == 0 {
+		return
+	}
+
+	// renderers that don't keep the last frame have to draw all of it
+	partial, ok := c.next.(partialRenderer)
+	redraw := rects
+	if !ok {
+		redraw = []image.Rectangle{c.screen()}
+	}
+
+	c.next.beginFrame()
+	for _, r := range redraw {
+		c.replay(r)
+	}
+	if c.debug {
+		c.next.setClip(nil)
+		for _, r := range rects {
+			col := color.RGBA{uint8(rand.Intn(256)), uint8(rand.Intn(256)), uint8(rand.Intn(256)), 50}
+			c.next.drawRect(float64(r.Min.X), float64(r.Min.Y), float64(r.Dx()), float64(r.Dy()), col)
+		}
+	}
+
+	if ok {
+		partial.endFrameRects(redraw)
+	} else {
+		c.next.endFrame()
+	}
+}
+
+// replay draws the commands that touch r, clipped to it.
+func (c *renCache) replay(r image.Rectangle) {
+	var clip image.Rectangle
+	for _, cmd := range c.cmds {
+		if !cmd.rect.Overlaps(r) {
+			continue
+		}
+		if cl := cmd.clip.Intersect(r); cl != clip {
+			clip = cl
+			c.next.setClip(&[4]float64{float64(cl.Min.X), float64(cl.Min.Y), float64(cl.Dx()), float64(cl.Dy())})
+		}
+
+		switch cmd.kind {
+			case drawCmdRect: c.next.drawRect(cmd.x, cmd.y, cmd.w, cmd.h, cmd.col)
+			case drawCmdText: c.next.drawText(cmd.font, cmd.spans, cmd.x, cmd.y)
+		}
+	}
+}
+
+// hashCells mixes h into the hash of every cell r touches.
+func (c *renCache) hashCells(r image.Rectangle, h uint32) {
+	x0, y0 := r.Min.X / renCellSize, r.Min.Y / renCellSize
+	x1, y1 := (r.Max.X - 1) / renCellSize, (r.Max.Y - 1) / renCellSize
+	for y := y0; y <= y1 && y < c.rows; y++ {
+		for x := x0; x <= x1 && x < c.cols; x++ {
+			i := x + y * c.cols
+			c.cells[i] = fnvUint(c.cells[i], uint64(h))
+		}
+	}
+}
+
+// mergeRect adds r to rects, joined with one it touches if there is one.
+func mergeRect(rects []image.Rectangle, r image.Rectangle) []image.Rectangle {
+	for i := len(rects) - 1; i >= 0; i-- {
+		o := rects[i]
+		if r.Min.X <= o.Max.X && o.Min.X <= r.Max.X && r.Min.Y <= o.Max.Y && o.Min.Y <= r.Max.Y {
+			rects[i] = o.Union(r)
+			return rects
+		}
+	}
+
+	return append(rects, r)
+}
+
+func (cmd drawCmd) hash() uint32 {
+	h := fnvUint(fnvOffset, uint64(cmd.kind))
+	for _, v := range []int{cmd.clip.Min.X, cmd.clip.Min.Y, cmd.clip.Max.X, cmd.clip.Max.Y} {
+		h = fnvUint(h, uint64(v))
+	}
+	for _, v := range []float64{cmd.x, cmd.y, cmd.w, cmd.h} {
+		h = fnvUint(h, math.Float64bits(v))
+	}
+	h = fnvUint(h, uint64(cmd.col.R) | uint64(cmd.col.G) << 8 | uint64(cmd.col.B) << 16 | uint64(cmd.col.A) << 24)
+
+	if cmd.font != nil {
+		// fonts can be resized in place, so the size counts too
+		h = fnvUint(h, uint64(uintptr(unsafe.Pointer(cmd.font))))
+		h = fnvUint(h, math.Float64bits(cmd.font.size()))
+		h = fnvUint(h, uint64(cmd.font.tabSize))
+	}
+	for _, s := range cmd.spans {
+		h = fnvString(h, s.text)
+		h = fnvUint(h, uint64(s.col.R) | uint64(s.col.G) << 8 | uint64(s.col.B) << 16 | uint64(s.col.A) << 24)
+	}
+
+	return h
+}
+
+const (
+	fnvOffset uint32 = 2166136261
+	fnvPrime uint32 = 16777619
+)
+
+func fnvUint(h uint32, v uint64) uint32 {
+	for i := 0; i < 8; i++ {
+		h ^= uint32(v & 0xff)
+		h *= fnvPrime
+		v >>= 8
+	}
+
+	return h
+}
+
+func fnvString(h uint32, s string) uint32 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= fnvPrime
+	}
+
+	return h
+}
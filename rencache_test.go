@@ -0,0 +1,48 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+// a line drawn a token at a time is laid out as one
+func TestRenCacheJoinsLine(t *testing.T) {
+	file, err := loadFontFile("data/fonts/JetBrainsMono-Regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFont(newFontFace(file, 14, defaultFontOptions))
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	c := newRenCache(newHeadlessRenderer(400, 100))
+	c.beginFrame()
+	x := c.drawText(f, []textSpan{{"abc ", red}}, 10, 10)
+	x = c.drawText(f, []textSpan{{"שלום", blue}}, x, 10)
+	x = c.drawText(f, []textSpan{{" עולם", red}}, x, 10)
+	// another line
+	c.drawText(f, []textSpan{{"abc", red}}, 10, 30)
+	c.drawText(f, []textSpan{{"def", red}}, x, 30)
+
+	if len(c.cmds) != 3 {
+		t.Fatalf("got %d commands, want 3", len(c.cmds))
+	}
+	line := c.cmds[0]
+	if len(line.spans) != 3 || line.end != x {
+		t.Fatalf("line has %d spans and ends at %v, not %v", len(line.spans), line.end, x)
+	}
+	var text string
+	for _, s := range line.spans {
+		text += s.text
+	}
+	// the line's width is that of its text laid out as one
+	if w := f.width(text); line.w != w {
+		t.Errorf("line is %v wide, want %v", line.w, w)
+	}
+
+	// the two words of the hebrew tokens swap places, which drawing them
+	// a token at a time wouldn't do
+	if got := visualText(text); got != "abc םלוע םולש" {
+		t.Errorf("line is shown as %q", got)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// renderer is what the renderer module draws with.
+type renderer interface{
+	beginFrame()
+	endFrame()
+	// setClip replaces the clip rect, nil clears it.
+	setClip(rect *[4]float64)
+	drawRect(x, y, w, h float64, col color.RGBA)
+	// drawText draws spans as a single line, see font.draw
+	drawText(f *font, spans []textSpan, x, y float64) float64
+	size() (w, h int)
+}
+
+var rend renderer
+
+// pixelRect is the rect of whole pixels a float one is drawn as, its edges
+// rounded to the nearest pixel like lite xl does.
+func pixelRect(x, y, w, h float64) image.Rectangle {
+	return image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x + w)), int(math.Round(y + h)))
+}
+
+// imageRenderer draws on the cpu, straight into an image.
+type imageRenderer struct{
+	img *image.RGBA
+	clip image.Rectangle
+}
+
+func newImageRenderer(w, h int) imageRenderer {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	return imageRenderer{img: img, clip: img.Rect}
+}
+
+// resize makes the image a new size, what was drawn is lost.
+func (r *imageRenderer) resize(w, h int) {
+	r.img = image.NewRGBA(image.Rect(0, 0, w, h))
+	r.clip = r.img.Rect
+}
+
+func (r *imageRenderer) setClip(rect *[4]float64) {
+	if rect == nil {
+		r.clip = r.img.Rect
+		return
+	}
+
+	r.clip = pixelRect(rect[0], rect[1], rect[2], rect[3]).Intersect(r.img.Rect)
+}
+
+func (r *imageRenderer) drawRect(x, y, w, h float64, col color.RGBA) {
+	rect := pixelRect(x, y, w, h).Intersect(r.clip)
+	if rect.Empty() || col.A == 0 {
+		return
+	}
+
+	for py := rect.Min.Y; py < rect.Max.Y; py++ {
+		row := r.img.Pix[r.img.PixOffset(rect.Min.X, py):r.img.PixOffset(rect.Max.X, py)]
+		for i := 0; i < len(row); i += 4 {
+			blendPixel(row[i:i + 4], col, col.A)
+		}
+	}
+}
+
+func (r *imageRenderer) drawGlyph(g cachedGlyph, at image.Point, col color.RGBA) {
+	rect := g.rect.Sub(g.rect.Min).Add(at).Intersect(r.clip)
+	if rect.Empty() || col.A == 0 {
+		return
+	}
+
+	src := rect.Min.Sub(at).Add(g.rect.Min)
+	page := g.page.img
+	for y := 0; y < rect.Dy(); y++ {
+		row := r.img.Pix[r.img.PixOffset(rect.Min.X, rect.Min.Y + y):]
+		cov := page.Pix[page.PixOffset(src.X, src.Y + y):]
+		for x := 0; x < rect.Dx(); x++ {
+			if cov[x] != 0 {
+				blendPixel(row[x * 4:x * 4 + 4], col, uint8(uint32(col.A) * uint32(cov[x]) / 255))
+			}
+		}
+	}
+}
+
+// blendPixel draws col over an rgba pixel with alpha a.
+func blendPixel(px []uint8, col color.RGBA, a uint8) {
+	if a == 255 {
+		px[0], px[1], px[2], px[3] = col.R, col.G, col.B, 255
+		return
+	}
+
+	sa, da := uint32(a), 255 - uint32(a)
+	px[0] = uint8((uint32(col.R) * sa + uint32(px[0]) * da) / 255)
+	px[1] = uint8((uint32(col.G) * sa + uint32(px[1]) * da) / 255)
+	px[2] = uint8((uint32(col.B) * sa + uint32(px[2]) * da) / 255)
+	px[3] = uint8(sa + uint32(px[3]) * da / 255)
+}
+
+func (r *imageRenderer) drawText(f *font, spans []textSpan, x, y float64) float64 {
+	return f.draw(r, spans, x, y)
+}
+
+// headlessRenderer draws to an image in memory, with no window or gl
+// context needed. it's in every build so tests can draw with it.
+type headlessRenderer struct{
+	imageRenderer
+}
+
+func newHeadlessRenderer(w, h int) *headlessRenderer {
+	return &headlessRenderer{newImageRenderer(w, h)}
+}
+
+func (h *headlessRenderer) beginFrame() {
+	h.setClip(nil)
+}
+
+func (h *headlessRenderer) endFrame() {
+}
+
+func (h *headlessRenderer) endFrameRects(rects []image.Rectangle) {
+}
+
+func (h *headlessRenderer) size() (int, int) {
+	return h.img.Rect.Dx(), h.img.Rect.Dy()
+}
+
+// image is what has been drawn so far.
+func (h *headlessRenderer) image() *image.RGBA {
+	return h.img
+}
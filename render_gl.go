@@ -0,0 +1,319 @@
+//go:build !headless
+// +build !headless
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"unsafe"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+)
+
+// glRenderer draws with opengl 3.2 on whatever framebuffer is bound. rects
+// and glyphs are both quads with a color of their own, glyphs sampling
+// their page of the atlas, which is kept on the gpu as a texture. so all
+// that's drawn between clip changes goes out in one draw call, unless
+// glyphs come from more than one page.
+type glRenderer struct{
+	prog, vao, vbo uint32
+	screenLoc int32
+	textures map[*glyphPage]uint32
+	// the atlas generation the textures are from
+	atlasGen int
+	verts []glVertex
+	// page the glyphs in verts are from, nil if there are none
+	page *glyphPage
+	w, h int // in window coordinates
+	fbw, fbh int // framebuffer size, bigger than w and h on hidpi screens
+	clip image.Rectangle
+}
+
+// glVertex is a corner of a quad. u is negative for rects, they don't
+// sample the atlas.
+type glVertex struct{
+	x, y float32
+	u, v float32
+	col color.RGBA
+}
+
+const glVertexShader = `
+#version 150
+in vec2 pos;
+in vec2 uv;
+in vec4 col;
+uniform vec2 screen;
+out vec2 fragUV;
+out vec4 fragCol;
+void main() {
+	gl_Position = vec4(pos.x / screen.x * 2.0 - 1.0, 1.0 - pos.y / screen.y * 2.0, 0.0, 1.0);
+	fragUV = uv;
+	fragCol = col;
+}
+`
+
+const glFragmentShader = `
+#version 150
+in vec2 fragUV;
+in vec4 fragCol;
+uniform sampler2D atlas;
+out vec4 outCol;
+void main() {
+	float cov = fragUV.x < 0.0 ? 1.0 : texture(atlas, fragUV).r;
+	outCol = vec4(fragCol.rgb, fragCol.a * cov);
+}
+`
+
+// init sets up what's needed to draw, with a context current.
+func (g *glRenderer) init() error {
+	if err := gl.Init(); err != nil {
+		return fmt.Errorf("Error initializing GL: %v", err)
+	}
+
+	prog, err := glProgram(glVertexShader, glFragmentShader)
+	if err != nil {
+		return err
+	}
+	g.prog = prog
+	g.screenLoc = gl.GetUniformLocation(prog, gl.Str("screen\x00"))
+	gl.UseProgram(prog)
+	gl.Uniform1i(gl.GetUniformLocation(prog, gl.Str("atlas\x00")), 0)
+
+	gl.GenVertexArrays(1, &g.vao)
+	gl.BindVertexArray(g.vao)
+	gl.GenBuffers(1, &g.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, g.vbo)
+
+	stride := int32(unsafe.Sizeof(glVertex{}))
+	gl.EnableVertexAttribArray(0)
+	gl.VertexAttribPointer(0, 2, gl.FLOAT, false, stride, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(1)
+	gl.VertexAttribPointer(1, 2, gl.FLOAT, false, stride, gl.PtrOffset(8))
+	gl.EnableVertexAttribArray(2)
+	gl.VertexAttribPointer(2, 4, gl.UNSIGNED_BYTE, true, stride, gl.PtrOffset(16))
+
+	g.textures = map[*glyphPage]uint32{}
+	g.atlasGen = fontAtlas.gen
+
+	return nil
+}
+
+// glProgram compiles and links a shader program, with the attributes at
+// the locations init sets up.
+func glProgram(vertexSrc, fragmentSrc string) (uint32, error) {
+	vs, err := glShader(gl.VERTEX_SHADER, vertexSrc)
+	if err != nil {
+		return 0, err
+	}
+	defer gl.DeleteShader(vs)
+	fs, err := glShader(gl.FRAGMENT_SHADER, fragmentSrc)
+	if err != nil {
+		return 0, err
+	}
+	defer gl.DeleteShader(fs)
+
+	prog := gl.CreateProgram()
+	gl.AttachShader(prog, vs)
+	gl.AttachShader(prog, fs)
+	gl.BindAttribLocation(prog, 0, gl.Str("pos\x00"))
+	gl.BindAttribLocation(prog, 1, gl.Str("uv\x00"))
+	gl.BindAttribLocation(prog, 2, gl.Str("col\x00"))
+	gl.LinkProgram(prog)
+
+	var status int32
+	gl.GetProgramiv(prog, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var n int32
+		gl.GetProgramiv(prog, gl.INFO_LOG_LENGTH, &n)
+		msg := strings.Repeat("\x00", int(n + 1))
+		gl.GetProgramInfoLog(prog, n, nil, gl.Str(msg))
+		gl.DeleteProgram(prog)
+		return 0, fmt.Errorf("Error linking shaders: %s", strings.TrimRight(msg, "\x00"))
+	}
+
+	return prog, nil
+}
+
+func glShader(kind uint32, src string) (uint32, error) {
+	shader := gl.CreateShader(kind)
+	csrc, free := gl.Strs(src + "\x00")
+	gl.ShaderSource(shader, 1, csrc, nil)
+	free()
+	gl.CompileShader(shader)
+
+	var status int32
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+	if status == gl.FALSE {
+		var n int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &n)
+		msg := strings.Repeat("\x00", int(n + 1))
+		gl.GetShaderInfoLog(shader, n, nil, gl.Str(msg))
+		gl.DeleteShader(shader)
+		return 0, fmt.Errorf("Error compiling shader: %s", strings.TrimRight(msg, "\x00"))
+	}
+
+	return shader, nil
+}
+
+// release frees what init made.
+func (g *glRenderer) release() {
+	for page, tex := range g.textures {
+		gl.DeleteTextures(1, &tex)
+		delete(g.textures, page)
+	}
+	gl.DeleteBuffers(1, &g.vbo)
+	gl.DeleteVertexArrays(1, &g.vao)
+	gl.DeleteProgram(g.prog)
+}
+
+// begin starts a frame w by h big, with a framebuffer of fbw by fbh.
+func (g *glRenderer) begin(w, h, fbw, fbh int) {
+	g.w, g.h, g.fbw, g.fbh = w, h, fbw, fbh
+	if g.atlasGen != fontAtlas.gen {
+		g.dropTextures()
+	}
+
+	gl.Viewport(0, 0, int32(fbw), int32(fbh))
+	gl.Disable(gl.SCISSOR_TEST)
+	gl.ClearColor(0, 0, 0, 1)
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+
+	gl.Enable(gl.SCISSOR_TEST)
+	gl.Enable(gl.BLEND)
+	gl.BlendFuncSeparate(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA, gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+	gl.UseProgram(g.prog)
+	gl.BindVertexArray(g.vao)
+	gl.BindBuffer(gl.ARRAY_BUFFER, g.vbo)
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.Uniform2f(g.screenLoc, float32(w), float32(h))
+
+	g.setClip(nil)
+}
+
+// end draws what's left of the frame.
+func (g *glRenderer) end() {
+	g.flush()
+}
+
+// dropTextures deletes the textures of pages that aren't in the atlas
+// anymore.
+func (g *glRenderer) dropTextures() {
+	current := map[*glyphPage]bool{}
+	for _, page := range fontAtlas.pages {
+		current[page] = true
+	}
+	for page, tex := range g.textures {
+		if !current[page] {
+			gl.DeleteTextures(1, &tex)
+			delete(g.textures, page)
+		}
+	}
+	g.atlasGen = fontAtlas.gen
+}
+
+func (g *glRenderer) setClip(rect *[4]float64) {
+	g.flush()
+
+	screen := image.Rect(0, 0, g.w, g.h)
+	g.clip = screen
+	if rect != nil {
+		g.clip = pixelRect(rect[0], rect[1], rect[2], rect[3]).Intersect(screen)
+	}
+
+	// the scissor box is in framebuffer pixels, from the bottom left
+	sx, sy := 1.0, 1.0
+	if g.w > 0 && g.h > 0 {
+		sx, sy = float64(g.fbw) / float64(g.w), float64(g.fbh) / float64(g.h)
+	}
+	x0, x1 := int32(math.Round(float64(g.clip.Min.X) * sx)), int32(math.Round(float64(g.clip.Max.X) * sx))
+	y0, y1 := int32(math.Round(float64(g.clip.Min.Y) * sy)), int32(math.Round(float64(g.clip.Max.Y) * sy))
+	gl.Scissor(x0, int32(g.fbh) - y1, x1 - x0, y1 - y0)
+}
+
+func (g *glRenderer) drawRect(x, y, w, h float64, col color.RGBA) {
+	r := pixelRect(x, y, w, h).Intersect(g.clip)
+	if r.Empty() || col.A == 0 {
+		return
+	}
+
+	g.quad(r, -1, -1, -1, -1, col)
+}
+
+func (g *glRenderer) drawGlyph(cg cachedGlyph, at image.Point, col color.RGBA) {
+	r := cg.rect.Sub(cg.rect.Min).Add(at)
+	if !r.Overlaps(g.clip) || col.A == 0 {
+		return
+	}
+
+	if g.page != cg.page {
+		if g.page != nil {
+			g.flush()
+		}
+		g.page = cg.page
+	}
+	pw, ph := float32(cg.page.img.Rect.Dx()), float32(cg.page.img.Rect.Dy())
+	g.quad(r, float32(cg.rect.Min.X) / pw, float32(cg.rect.Min.Y) / ph, float32(cg.rect.Max.X) / pw, float32(cg.rect.Max.Y) / ph, col)
+}
+
+func (g *glRenderer) drawText(f *font, spans []textSpan, x, y float64) float64 {
+	return f.draw(g, spans, x, y)
+}
+
+// quad adds a rect with the part of the page from u0, v0 to u1, v1.
+func (g *glRenderer) quad(r image.Rectangle, u0, v0, u1, v1 float32, col color.RGBA) {
+	x0, y0, x1, y1 := float32(r.Min.X), float32(r.Min.Y), float32(r.Max.X), float32(r.Max.Y)
+	g.verts = append(g.verts,
+		glVertex{x0, y0, u0, v0, col}, glVertex{x0, y1, u0, v1, col}, glVertex{x1, y1, u1, v1, col},
+		glVertex{x0, y0, u0, v0, col}, glVertex{x1, y1, u1, v1, col}, glVertex{x1, y0, u1, v0, col})
+}
+
+// flush draws the quads collected so far.
+func (g *glRenderer) flush() {
+	if len(g.verts) == 0 {
+		return
+	}
+
+	if g.page != nil {
+		gl.BindTexture(gl.TEXTURE_2D, g.texture(g.page))
+	}
+	gl.BufferData(gl.ARRAY_BUFFER, len(g.verts) * int(unsafe.Sizeof(glVertex{})), unsafe.Pointer(&g.verts[0]), gl.STREAM_DRAW)
+	gl.DrawArrays(gl.TRIANGLES, 0, int32(len(g.verts)))
+
+	g.verts = g.verts[:0]
+	g.page = nil
+}
+
+// texture returns the texture of a page, uploading what was added to the
+// page since the last time.
+func (g *glRenderer) texture(page *glyphPage) uint32 {
+	img := page.img
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+
+	tex, ok := g.textures[page]
+	if !ok {
+		gl.GenTextures(1, &tex)
+		gl.BindTexture(gl.TEXTURE_2D, tex)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.R8, int32(img.Rect.Dx()), int32(img.Rect.Dy()), 0, gl.RED, gl.UNSIGNED_BYTE, unsafe.Pointer(&img.Pix[0]))
+		g.textures[page] = tex
+		page.dirty = image.Rectangle{}
+		return tex
+	}
+
+	if d := page.dirty; !d.Empty() {
+		gl.BindTexture(gl.TEXTURE_2D, tex)
+		gl.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(img.Stride))
+		gl.TexSubImage2D(gl.TEXTURE_2D, 0, int32(d.Min.X), int32(d.Min.Y), int32(d.Dx()), int32(d.Dy()), gl.RED, gl.UNSIGNED_BYTE, unsafe.Pointer(&img.Pix[img.PixOffset(d.Min.X, d.Min.Y)]))
+		gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+		page.dirty = image.Rectangle{}
+	}
+
+	return tex
+}
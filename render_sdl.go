@@ -0,0 +1,212 @@
+//go:build !headless
+// +build !headless
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"runtime"
+	"unsafe"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// mainWindow is the window everything is drawn to.
+var mainWindow *sdl.Window
+
+// sdl wants its video calls from the main thread, which main runs on as long
+// as it's locked before main starts
+func init() {
+	runtime.LockOSThread()
+}
+
+// newRenderer opens the window and draws to it with opengl, or on the cpu
+// if that doesn't work or --software was given. cleanup closes it again.
+func newRenderer(w, h int) (renderer, func(), error) {
+	wnd, err := newWindow(w, h, !software)
+	if err != nil {
+		return nil, nil, err
+	}
+	mainWindow = wnd
+
+	if !software {
+		wr, err := newWindowRenderer(wnd)
+		if err == nil {
+			return wr, func() {
+				wr.destroy()
+				wnd.Destroy()
+			}, nil
+		}
+		log.Println(err)
+		log.Println("falling back to software rendering")
+
+		// a window made for opengl has no surface to draw to on the cpu
+		wnd.Destroy()
+		if wnd, err = newWindow(w, h, false); err != nil {
+			return nil, nil, err
+		}
+		mainWindow = wnd
+	}
+
+	return newSurfaceRenderer(wnd), func() { wnd.Destroy() }, nil
+}
+
+// newWindow opens the window to draw in, made for opengl if withGL is set
+// and it can be.
+func newWindow(w, h int, withGL bool) (*sdl.Window, error) {
+	if err := sdl.Init(sdl.INIT_VIDEO); err != nil {
+		return nil, fmt.Errorf("Error initializing SDL: %v", err)
+	}
+
+	if withGL {
+		sdl.GLSetAttribute(sdl.GL_CONTEXT_MAJOR_VERSION, 3)
+		sdl.GLSetAttribute(sdl.GL_CONTEXT_MINOR_VERSION, 2)
+		sdl.GLSetAttribute(sdl.GL_CONTEXT_PROFILE_MASK, sdl.GL_CONTEXT_PROFILE_CORE)
+		sdl.GLSetAttribute(sdl.GL_RED_SIZE, 8)
+		sdl.GLSetAttribute(sdl.GL_GREEN_SIZE, 8)
+		sdl.GLSetAttribute(sdl.GL_BLUE_SIZE, 8)
+		sdl.GLSetAttribute(sdl.GL_DEPTH_SIZE, 0)
+		sdl.GLSetAttribute(sdl.GL_DOUBLEBUFFER, 1)
+
+		flags := uint32(sdl.WINDOW_RESIZABLE | sdl.WINDOW_OPENGL | sdl.WINDOW_ALLOW_HIGHDPI)
+		wnd, err := sdl.CreateWindow("", sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED, int32(w), int32(h), flags)
+		if err == nil {
+			return wnd, nil
+		}
+		log.Printf("Error creating opengl window: %v", err)
+	}
+
+	wnd, err := sdl.CreateWindow("", sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED, int32(w), int32(h), sdl.WINDOW_RESIZABLE)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating window: %v", err)
+	}
+
+	return wnd, nil
+}
+
+// windowRenderer draws to an sdl window with opengl.
+type windowRenderer struct{
+	glRenderer
+	wnd *sdl.Window
+	ctx sdl.GLContext
+}
+
+// newWindowRenderer sets up opengl on a window made for it by newWindow.
+// nothing is left behind if that fails, so the window can still be used
+// by another renderer.
+func newWindowRenderer(wnd *sdl.Window) (*windowRenderer, error) {
+	if wnd.GetFlags() & sdl.WINDOW_OPENGL == 0 {
+		return nil, fmt.Errorf("window has no opengl")
+	}
+
+	ctx, err := wnd.GLCreateContext()
+	if err != nil {
+		return nil, fmt.Errorf("Error creating GL context: %v", err)
+	}
+
+	w := &windowRenderer{wnd: wnd, ctx: ctx}
+	if err := w.init(); err != nil {
+		sdl.GLDeleteContext(ctx)
+		return nil, err
+	}
+	sdl.GLSetSwapInterval(1)
+
+	return w, nil
+}
+
+// destroy lets go of the gl context, the window stays.
+func (w *windowRenderer) destroy() {
+	w.wnd.GLMakeCurrent(w.ctx)
+	w.release()
+	sdl.GLDeleteContext(w.ctx)
+}
+
+func (w *windowRenderer) beginFrame() {
+	w.wnd.GLMakeCurrent(w.ctx)
+	width, height := w.size()
+	fbw, fbh := w.wnd.GLGetDrawableSize()
+	w.begin(width, height, int(fbw), int(fbh))
+}
+
+func (w *windowRenderer) endFrame() {
+	w.end()
+	w.wnd.GLSwap()
+}
+
+func (w *windowRenderer) size() (int, int) {
+	width, height := w.wnd.GetSize()
+	return int(width), int(height)
+}
+
+// surfaceRenderer draws on the cpu and copies frames to the surface of an
+// sdl window, for when there's no working opengl.
+type surfaceRenderer struct{
+	imageRenderer
+	wnd *sdl.Window
+}
+
+func newSurfaceRenderer(wnd *sdl.Window) *surfaceRenderer {
+	w, h := wnd.GetSize()
+	return &surfaceRenderer{
+		imageRenderer: newImageRenderer(int(w), int(h)),
+		wnd: wnd,
+	}
+}
+
+func (s *surfaceRenderer) beginFrame() {
+	// the image follows the window size
+	w, h := s.size()
+	if w != s.img.Rect.Dx() || h != s.img.Rect.Dy() {
+		s.resize(w, h)
+	}
+	s.setClip(nil)
+}
+
+func (s *surfaceRenderer) endFrame() {
+	s.present(nil)
+}
+
+func (s *surfaceRenderer) endFrameRects(rects []image.Rectangle) {
+	s.present(rects)
+}
+
+// present copies rects of the image to the window, or all of it for nil.
+func (s *surfaceRenderer) present(rects []image.Rectangle) {
+	img := s.img
+	if len(img.Pix) == 0 {
+		return
+	}
+	dst, err := s.wnd.GetSurface()
+	if err != nil {
+		return
+	}
+
+	// blitting converts to whatever format the window has
+	src, err := sdl.CreateRGBSurfaceWithFormatFrom(unsafe.Pointer(&img.Pix[0]), int32(img.Rect.Dx()), int32(img.Rect.Dy()), 32, int32(img.Stride), uint32(sdl.PIXELFORMAT_RGBA32))
+	if err != nil {
+		return
+	}
+	defer src.Free()
+	src.SetBlendMode(sdl.BLENDMODE_NONE)
+
+	if rects == nil {
+		src.Blit(nil, dst, nil)
+		s.wnd.UpdateSurface()
+		return
+	}
+
+	sdlRects := make([]sdl.Rect, len(rects))
+	for i, r := range rects {
+		sdlRects[i] = sdl.Rect{X: int32(r.Min.X), Y: int32(r.Min.Y), W: int32(r.Dx()), H: int32(r.Dy())}
+		dstRect := sdlRects[i]
+		src.Blit(&sdlRects[i], dst, &dstRect)
+	}
+	s.wnd.UpdateSurfaceRects(sdlRects)
+}
+
+func (s *surfaceRenderer) size() (int, int) {
+	w, h := s.wnd.GetSize()
+	return int(w), int(h)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-text/typesetting/di"
+	"github.com/go-text/typesetting/language"
+	"github.com/go-text/typesetting/shaping"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+var shaper shaping.HarfbuzzShaper
+
+// shaped text kept per face before starting over
+const shapeCacheSize = 4096
+
+type shapeKey struct{
+	text string
+	script language.Script
+	rtl bool
+}
+
+// shapedGlyph is a glyph where the shaper put it, in pixels.
+type shapedGlyph struct{
+	idx sfnt.GlyphIndex
+	x, y float64 // from the pen, y goes up
+	advance float64
+	cluster int // byte in the text the glyph was made from
+}
+
+// textScript is the script of a rune, and false for runes that are used
+// with any script, like spaces and marks, and go with what's around them.
+func textScript(r rune) (language.Script, bool) {
+	s := language.LookupScript(r)
+	return s, s != language.Common && s != language.Inherited && s != language.Unknown
+}
+
+// shape shapes text of a single script and direction with the face.
+// glyphs come in visual order, so right to left text is already reversed.
+func (f *fontFace) shape(text string, script language.Script, rtl bool) []shapedGlyph {
+	key := shapeKey{text, script, rtl}
+	if glyphs, ok := f.cache.shaped[key]; ok {
+		return glyphs
+	}
+
+	runes := []rune(text)
+	dir := di.DirectionLTR
+	if rtl {
+		dir = di.DirectionRTL
+	}
+
+	// the shaper only takes whole pixel sizes, shaping at the em size in
+	// font units and scaling down keeps fractional sizes right
+	upem := float64(f.file.ts.Upem())
+	out := shaper.Shape(shaping.Input{
+		Text: runes,
+		RunStart: 0,
+		RunEnd: len(runes),
+		Direction: dir,
+		Face: f.file.ts,
+		Size: fixed.I(int(upem)),
+		Script: script,
+		Language: language.DefaultLanguage(),
+	})
+
+	// clusters are rune indexes, turn them into byte ones
+	offsets := make([]int, 0, len(runes))
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+
+	scale := f.size / upem
+	bold := f.emboldening()
+	if f.opts.hinting == fontHintingFull {
+		bold = math.Round(bold)
+	}
+	glyphs := make([]shapedGlyph, 0, len(out.Glyphs))
+	for _, g := range out.Glyphs {
+		adv := float64(g.XAdvance) / 64 * scale
+		if f.opts.hinting == fontHintingFull {
+			adv = math.Round(adv)
+		}
+		glyphs = append(glyphs, shapedGlyph{
+			idx: sfnt.GlyphIndex(g.GlyphID),
+			x: float64(g.XOffset) / 64 * scale,
+			y: float64(g.YOffset) / 64 * scale,
+			advance: adv + bold,
+			cluster: offsets[g.ClusterIndex],
+		})
+	}
+
+	if len(f.cache.shaped) >= shapeCacheSize {
+		f.cache.shaped = map[shapeKey][]shapedGlyph{}
+	}
+	f.cache.shaped[key] = glyphs
+
+	return glyphs
+}